@@ -18,8 +18,13 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/square/goprotowrap"
 	"github.com/square/goprotowrap/wrapper"
@@ -28,46 +33,235 @@ import (
 // customFlags is a map describing flags we add to protoc. true means
 // a value is required. false implies boolean.
 var customFlags = map[string]bool{
+	"baseline":             true,
+	"cache_dir":            true,
+	"changed_files":        true,
+	"config":               true,
+	"error_format":         true,
+	"exclude":              true,
+	"format":               true,
+	"graph":                true,
+	"include":              true,
+	"parallelism":          true,
+	"parser":               true,
 	"print_structure":      false,
 	"protoc_command":       true,
+	"protoc_timeout":       true,
+	"rules":                true,
+	"suggest":              false,
 	"only_specified_files": false,
+	"q":                    false,
+	"v":                    false,
 	"version":              false,
+	"write_baseline":       false,
 }
 
 func usageAndExit(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, format, args...)
 	fmt.Fprintf(os.Stderr, "Usage: %s [flags] [protofiles]\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, `  --only_specified_files true|false
+	fmt.Fprintf(os.Stderr, `  --baseline file
+      JSON file listing accepted cycles; only cycles that are new, or that grow
+      beyond an accepted one, are reported
+  --cache_dir string
+      if set, cache parsed .proto file information in this directory between runs
+  --changed_files files
+      only check the packages containing these files, and the packages that
+      transitively import them; a comma-separated list, or @file naming a file
+      with one filename per line (repeatable)
+  --config file
+      project config file (default: the nearest protowrap.yaml in the working
+      directory or its ancestors); commandline arguments override it
+  --error_format json|github|gcc|msvs
+      if json or github, also write protoc's errors and warnings to stdout as a
+      JSON document or as GitHub Actions annotations; gcc and msvs are passed
+      on to protoc. With json, the document is written even if there are no
+      errors, and nothing else is written to stdout. json and github cannot be
+      used with --format=json, --graph or --print_structure
+  --exclude glob
+      don't use, or walk into, .proto files or directories found in import
+      directories whose import-path-relative names match the glob (repeatable).
+      .protowrapignore files in import directories are also honored, with
+      gitignore semantics
+  --format text|json
+      output format for cycles found; json writes a document describing each
+      cycle's packages and file-level imports to stdout, with nothing else
+      written to it, so it cannot be used with --print_structure, --graph or
+      --error_format=json|github (default "text")
+  --graph dot|mermaid
+      write the package dependency graph in Graphviz DOT or Mermaid format
+      instead of checking for cycles, with nothing else written to stdout, so it
+      cannot be used with --print_structure
+  --include glob
+      only use .proto files found in import directories whose
+      import-path-relative names match the glob (repeatable)
+  --only_specified_files true|false
       if true, don't search the nearest import path ancestor for other .proto files
+  --parallelism int
+      parallelism when collecting filedescriptors (default 5)
+  --parser protoc|go
+      how to read .proto files when computing packages: "protoc" calls protoc,
+      "go" parses them in-process (default "protoc")
   --protoc_command string
       command to use to call protoc (default "protoc")
-  --print_structure
-      if true, print out computed package structure
+  --protoc_timeout duration
+      if set, kill protoc calls that take longer than this, eg. "5m"
+  --print_structure[=json]
+      if set, print out computed package structure; with =json, as a JSON
+      document, and with nothing else written to stdout, so it cannot be used
+      with --format=json, --graph or --error_format=json|github
+  --rules file
+      JSON file of layering rules to check package imports against, in the
+      form {"rules": [{"name": ..., "from": [...], "except_from": [...],
+      "deny": [...], "allow": [...]}]}
+  --suggest
+      if true, suggest imports to remove, or files to move to a different
+      go_package, to break each cycle found
+  --write_baseline
+      if true, write the cycles currently found to the --baseline file and exit
+  -q
+      only print warnings and errors
+  -v
+      also print protoc command lines, and how long each protoc call took
 `)
 	os.Exit(1)
 }
 
+// writeDiagnostics writes the protoc diagnostics carried by err, which
+// may be nil, to stdout, if an --error_format was requested. With
+// json, a document is written even if there are no diagnostics.
+func writeDiagnostics(err error, format string) {
+	if format == "" {
+		return
+	}
+	if err := wrapper.WriteDiagnostics(os.Stdout, wrapper.Diagnostics(err), format); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+}
+
+// newLogger returns the logger for the -q and -v flags. If stdout
+// carries machine-readable output, nothing is logged to it: progress
+// messages are dropped unless -v is given, and go to stderr if it is.
+func newLogger(flags wrapper.FlagValues, machineOutput bool) wrapper.Logger {
+	level := slog.LevelInfo
+	if flags.Has("q") {
+		level = slog.LevelWarn
+	} else if flags.Has("v") {
+		level = slog.LevelDebug
+	}
+	if !machineOutput {
+		return wrapper.NewLogger(os.Stdout, os.Stderr, level)
+	}
+	if level == slog.LevelInfo {
+		level = slog.LevelWarn
+	}
+	return wrapper.NewLogger(os.Stderr, os.Stderr, level)
+}
+
 func main() {
-	flags, protocFlags, protos, importDirs, err := wrapper.ParseArgs(os.Args[1:], customFlags)
+	repeated, protocFlags, protos, importDirs, err := wrapper.ParseRepeatedArgs(os.Args[1:], customFlags)
 	if err != nil {
 		usageAndExit("Error: %v\n", err)
 	}
+	flags := repeated.Last()
 	if flags.Has("version") {
 		fmt.Println(goprotowrap.Version)
 		os.Exit(0)
 	}
+	if flags.Has("q") && flags.Has("v") {
+		usageAndExit("Error: -q and -v cannot be used together\n")
+	}
+	config, err := wrapper.LoadConfigForFlags(flags)
+	if err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	var include, exclude []string
+	if config != nil {
+		protocFlags, importDirs, err = config.Merge(flags, protocFlags, importDirs)
+		if err != nil {
+			usageAndExit("Error: %v\n", err)
+		}
+		include, exclude = config.Include, config.Exclude
+	}
+	if flags.Has("include") {
+		include = repeated.Strings("include")
+	}
+	if flags.Has("exclude") {
+		exclude = repeated.Strings("exclude")
+	}
 	if len(importDirs) == 0 {
 		usageAndExit("Error: at least one import directory (-I) needed\n")
 	}
 
+	errorFormat := flags.String("error_format", "")
+	switch errorFormat {
+	case "", wrapper.DiagnosticsJSON, wrapper.DiagnosticsGitHub:
+	default:
+		// Anything else is one of protoc's own formats.
+		protocFlags = append(protocFlags, "--error_format="+errorFormat)
+		errorFormat = ""
+	}
+
 	noExpand, err := flags.Bool("only_specified_files", false)
 	if err != nil {
 		usageAndExit("Error: %v\n", err)
 	}
-	printStructure, err := flags.Bool("print_structure", false)
+	parallelism, err := flags.Int("parallelism", 5)
+	if err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	protocTimeout, err := flags.Duration("protoc_timeout", 0)
+	if err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	printStructure, printStructureJSON := false, false
+	if flags.String("print_structure", "") == "json" {
+		printStructureJSON = true
+	} else if printStructure, err = flags.Bool("print_structure", false); err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+
+	suggest, err := flags.Bool("suggest", false)
+	if err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	writeBaseline, err := flags.Bool("write_baseline", false)
+	if err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	baselineFile := flags.String("baseline", "")
+	if writeBaseline && baselineFile == "" {
+		usageAndExit("Error: --write_baseline requires --baseline\n")
+	}
+	changedFiles, err := repeated.FileList("changed_files")
 	if err != nil {
 		usageAndExit("Error: %v\n", err)
 	}
+	if writeBaseline && changedFiles != nil {
+		usageAndExit("Error: --write_baseline cannot be used with --changed_files\n")
+	}
+	var rules []wrapper.LayeringRule
+	if flags.Has("rules") {
+		if rules, err = wrapper.ReadLayeringRules(flags.String("rules", "")); err != nil {
+			usageAndExit("Error: %v\n", err)
+		}
+	}
+	format := flags.String("format", "text")
+	if format != "text" && format != "json" {
+		usageAndExit("Error: unknown --format %q\n", format)
+	}
+	if format == "json" && (printStructure || printStructureJSON || flags.Has("graph") || errorFormat != "") {
+		usageAndExit("Error: --format=json cannot be used with --print_structure, --graph or --error_format=json|github\n")
+	}
+	if errorFormat != "" && (printStructure || printStructureJSON || flags.Has("graph")) {
+		usageAndExit("Error: --error_format=json|github cannot be used with --print_structure or --graph\n")
+	}
+	if flags.Has("graph") && (printStructure || printStructureJSON) {
+		usageAndExit("Error: --graph cannot be used with --print_structure\n")
+	}
+	if printStructureJSON && (format == "json" || flags.Has("graph") || errorFormat != "") {
+		usageAndExit("Error: --print_structure=json cannot be used with --format=json, --graph or --error_format=json|github\n")
+	}
+	logger := newLogger(flags, printStructureJSON || flags.Has("graph") || format == "json" || errorFormat == wrapper.DiagnosticsJSON)
 
 	w := &wrapper.Wrapper{
 		ProtocCommand: flags.String("protoc_command", "protoc"),
@@ -75,20 +269,103 @@ func main() {
 		ProtoFiles:    protos,
 		ImportDirs:    importDirs,
 		NoExpand:      noExpand,
+		Include:       include,
+		Exclude:       exclude,
+		Parser:        flags.String("parser", wrapper.ParserProtoc),
+		CacheDir:      flags.String("cache_dir", ""),
+		Parallelism:   parallelism,
+		ChangedFiles:  changedFiles,
+		ProtocTimeout: protocTimeout,
+		Logger:        logger,
 	}
-	err = w.Init()
+	// On SIGINT or SIGTERM, kill any protoc calls in progress and stop.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = w.InitContext(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		writeDiagnostics(err, errorFormat)
 		os.Exit(1)
 	}
+	// protoc isn't called after Init, so there are no more diagnostics
+	// to come.
+	writeDiagnostics(nil, errorFormat)
 
 	// Debugging output.
 	if printStructure {
 		w.PrintStructure(os.Stdout)
 	}
+	if printStructureJSON {
+		if err := w.PrintStructureJSON(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if flags.Has("graph") {
+		if err := w.WriteGraph(os.Stdout, flags.String("graph", "")); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if writeBaseline {
+		if err := wrapper.WriteCycleBaseline(baselineFile, w.Cycles()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	err = w.CheckCycles()
+	if cycleErr, ok := err.(*wrapper.CycleError); ok && baselineFile != "" {
+		baseline, err2 := wrapper.ReadCycleBaseline(baselineFile)
+		if err2 != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot read baseline: %v\n", err2)
+			os.Exit(1)
+		}
+		cycleErr.Cycles = wrapper.NewCycles(cycleErr.Cycles, baseline)
+		if len(cycleErr.Cycles) == 0 {
+			err = nil
+		}
+	}
+	if cycleErr, ok := err.(*wrapper.CycleError); ok && suggest {
+		w.SuggestCycleBreaks(cycleErr)
+	}
 
-	if err := w.CheckCycles(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v", err)
+	var layeringErr error
+	if len(rules) > 0 {
+		layeringErr = w.CheckLayering(rules)
+	}
+
+	if format == "json" {
+		result := struct {
+			Cycles     []wrapper.Cycle             `json:"cycles"`
+			Violations []wrapper.LayeringViolation `json:"violations,omitempty"`
+		}{Cycles: []wrapper.Cycle{}}
+		if cycleErr, ok := err.(*wrapper.CycleError); ok {
+			result.Cycles = cycleErr.Cycles
+		}
+		if layeringErr, ok := layeringErr.(*wrapper.LayeringError); ok {
+			result.Violations = layeringErr.Violations
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v", err)
+		}
+		if layeringErr != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v", layeringErr)
+		}
+	}
+	if err != nil || layeringErr != nil {
 		os.Exit(2)
 	}
 }
@@ -0,0 +1,90 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/square/goprotowrap/wrapper"
+	"github.com/square/goprotowrap/wrapper/wrappertest"
+)
+
+// TestMain runs cyclecheck itself, instead of the tests, if
+// CYCLECHECK_TEST_MAIN is set.
+func TestMain(m *testing.M) {
+	if os.Getenv("CYCLECHECK_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestErrorFormatJSON(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake protoc is a shell script")
+	}
+	tree := wrappertest.NewTree(t).
+		Add("a/a.proto", "ex.com/a", "b/b.proto").
+		Add("b/b.proto", "ex.com/b")
+
+	// cyclecheck only calls protoc to read .proto files, so the
+	// failure needs --parser=protoc.
+	tests := map[string]struct {
+		parser      string
+		protoc      string
+		diagnostics []wrapper.Diagnostic
+	}{
+		"success": {"go", "exit 0", []wrapper.Diagnostic{}},
+		"failure": {
+			"protoc",
+			"echo 'b/b.proto:3:5: boom' >&2; exit 1",
+			[]wrapper.Diagnostic{{File: "b/b.proto", Line: 3, Column: 5, Severity: "error", Message: "boom"}},
+		},
+	}
+	for name, tt := range tests {
+		protoc := filepath.Join(t.TempDir(), "protoc")
+		if err := ioutil.WriteFile(protoc, []byte("#!/bin/sh\n"+tt.protoc+"\n"), 0777); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(os.Args[0], "--parser="+tt.parser, "--parallelism=1", "--error_format=json",
+			"--protoc_command="+protoc, "-I"+tree.Dir, tree.Path("b/b.proto"))
+		cmd.Env = append(os.Environ(), "CYCLECHECK_TEST_MAIN=1")
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		err := cmd.Run()
+		if (err != nil) != (tt.protoc != "exit 0") {
+			t.Errorf("%s: unexpected exit: %v", name, err)
+		}
+
+		var got struct {
+			Diagnostics []wrapper.Diagnostic `json:"diagnostics"`
+		}
+		if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+			t.Errorf("%s: cannot parse stdout %q: %v", name, stdout.String(), err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Diagnostics, tt.diagnostics) {
+			t.Errorf("%s: want diagnostics %+v; got %+v", name, tt.diagnostics, got.Diagnostics)
+		}
+	}
+}
@@ -18,8 +18,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/square/goprotowrap"
 	"github.com/square/goprotowrap/wrapper"
@@ -28,29 +32,113 @@ import (
 // customFlags is a map describing flags we add to protoc. true means
 // a value is required. false implies boolean.
 var customFlags = map[string]bool{
+	"atomic":               false,
+	"cache_dir":            true,
+	"changed_files":        true,
+	"check":                false,
+	"clean":                false,
+	"config":               true,
+	"dependency_order":     false,
+	"error_format":         true,
+	"exclude":              true,
+	"force":                false,
+	"include":              true,
+	"keep_going":           false,
 	"parallelism":          true,
+	"parser":               true,
 	"print_structure":      false,
 	"protoc_command":       true,
+	"protoc_timeout":       true,
 	"only_specified_files": false,
 	"print_only":           false,
+	"q":                    false,
+	"since":                true,
+	"stamp_dir":            true,
+	"v":                    false,
 	"version":              false,
+	"watch":                false,
 }
 
 func usageAndExit(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, format, args...)
 	fmt.Fprintf(os.Stderr, "Usage: %s [flags] [protofiles]\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, `  --only_specified_files true|false
+	fmt.Fprintf(os.Stderr, `  --atomic
+      if true, generate into staging directories, and only move the generated
+      files into the output directories if every package succeeds, leaving
+      files whose contents are unchanged untouched
+  --cache_dir string
+      if set, cache parsed .proto file information in this directory between runs
+  --changed_files files
+      only generate (and check for cycles) the packages containing these files,
+      and the packages that transitively import them; a comma-separated list,
+      or @file naming a file with one filename per line (repeatable)
+  --check
+      if true, generate into a temporary directory instead, and exit with
+      status 3, listing the differences, if any generated file doesn't match
+      the one in the output directories (other failures exit with status 1)
+  --clean[=dry_run]
+      if set, after generating, delete .pb.go files with the protoc-gen-go
+      "DO NOT EDIT" header in the output directories that no longer correspond
+      to any .proto file; with =dry_run, just list them, on stderr if stdout
+      carries --print_structure=json or --error_format=json output
+  --config file
+      project config file (default: the nearest protowrap.yaml in the working
+      directory or its ancestors); commandline arguments override it
+  --dependency_order
+      if true, only generate a package once all the packages it imports are generated
+  --error_format json|github|gcc|msvs
+      if json or github, also write protoc's errors and warnings to stdout as a
+      JSON document or as GitHub Actions annotations; gcc and msvs are passed
+      on to protoc. With json, the document is written even if there are no
+      errors, and nothing else is written to stdout, so it cannot be used with
+      --print_only or --print_structure
+  --exclude glob
+      don't use, or walk into, .proto files or directories found in import
+      directories whose import-path-relative names match the glob (repeatable).
+      .protowrapignore files in import directories are also honored, with
+      gitignore semantics
+  --force
+      if true, regenerate all packages, even if their --stamp_dir stamps are unchanged
+  --include glob
+      only use .proto files found in import directories whose
+      import-path-relative names match the glob (repeatable)
+  --keep_going
+      if true, generate every package even if some fail, and report all the
+      failures at the end
+  --only_specified_files true|false
       if true, don't search the nearest import path ancestor for other .proto files
   --parallelism int
-      parallelism when generating (default 5)
+      parallelism when collecting filedescriptors and generating (default 5)
+  --parser protoc|go
+      how to read .proto files when computing packages: "protoc" calls protoc,
+      "go" parses them in-process (default "protoc")
   --protoc_command string
       command to use to call protoc (default "protoc")
-  --print_structure
-      if true, print out computed package structure
+  --protoc_timeout duration
+      if set, kill protoc calls that take longer than this, eg. "5m"
+  --print_structure[=json]
+      if set, print out computed package structure; with =json, as a JSON
+      document, and with nothing else written to stdout, so it cannot be used
+      with --print_only or --error_format=json|github
   --print_only
       if true, print protoc commandlines instead of generating protos
+  --since git-ref
+      only generate the packages affected by .proto files in the import
+      directories that differ between git-ref and the working tree, as with
+      --changed_files (which it adds to)
+  --stamp_dir string
+      if set, record a stamp per package in this directory, and skip packages
+      whose inputs, flags and protoc/plugin binaries are unchanged, and whose
+      protoc-gen-go outputs still exist
   --version
       print version and exit
+  --watch
+      if true, keep running after generating, and regenerate affected packages
+      whenever .proto files in the import directories change (Linux only)
+  -q
+      only print warnings and errors
+  -v
+      also print protoc command lines, and how long each protoc call took
   @file
       read command line arguments from the named file. Each line of the file
       will become a single argument at the position where @file is used.
@@ -58,19 +146,81 @@ func usageAndExit(format string, args ...interface{}) {
 	os.Exit(1)
 }
 
+// writeDiagnostics writes the protoc diagnostics carried by err, which
+// may be nil, to stdout, if an --error_format was requested. With
+// json, a document is written even if there are no diagnostics.
+func writeDiagnostics(err error, format string) {
+	if format == "" {
+		return
+	}
+	if err := wrapper.WriteDiagnostics(os.Stdout, wrapper.Diagnostics(err), format); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+}
+
+// newLogger returns the logger for the -q and -v flags. If stdout
+// carries machine-readable output, nothing is logged to it: progress
+// messages are dropped unless -v is given, and go to stderr if it is.
+func newLogger(flags wrapper.FlagValues, machineOutput bool) wrapper.Logger {
+	level := slog.LevelInfo
+	if flags.Has("q") {
+		level = slog.LevelWarn
+	} else if flags.Has("v") {
+		level = slog.LevelDebug
+	}
+	if !machineOutput {
+		return wrapper.NewLogger(os.Stdout, os.Stderr, level)
+	}
+	if level == slog.LevelInfo {
+		level = slog.LevelWarn
+	}
+	return wrapper.NewLogger(os.Stderr, os.Stderr, level)
+}
+
 func main() {
-	flags, protocFlags, protos, importDirs, err := wrapper.ParseArgs(os.Args[1:], customFlags)
+	repeated, protocFlags, protos, importDirs, err := wrapper.ParseRepeatedArgs(os.Args[1:], customFlags)
 	if err != nil {
 		usageAndExit("Error: %v\n", err)
 	}
+	flags := repeated.Last()
 	if flags.Has("version") {
 		fmt.Println(goprotowrap.Version)
 		os.Exit(0)
 	}
+	if flags.Has("q") && flags.Has("v") {
+		usageAndExit("Error: -q and -v cannot be used together\n")
+	}
+	config, err := wrapper.LoadConfigForFlags(flags)
+	if err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	var include, exclude []string
+	if config != nil {
+		protocFlags, importDirs, err = config.Merge(flags, protocFlags, importDirs)
+		if err != nil {
+			usageAndExit("Error: %v\n", err)
+		}
+		include, exclude = config.Include, config.Exclude
+	}
+	if flags.Has("include") {
+		include = repeated.Strings("include")
+	}
+	if flags.Has("exclude") {
+		exclude = repeated.Strings("exclude")
+	}
 	if len(importDirs) == 0 {
 		usageAndExit("Error: at least one import directory (-I) needed\n")
 	}
 
+	errorFormat := flags.String("error_format", "")
+	switch errorFormat {
+	case "", wrapper.DiagnosticsJSON, wrapper.DiagnosticsGitHub:
+	default:
+		// Anything else is one of protoc's own formats.
+		protocFlags = append(protocFlags, "--error_format="+errorFormat)
+		errorFormat = ""
+	}
+
 	noExpand, err := flags.Bool("only_specified_files", false)
 	if err != nil {
 		usageAndExit("Error: %v\n", err)
@@ -79,27 +229,116 @@ func main() {
 	if err != nil {
 		usageAndExit("Error: %v\n", err)
 	}
-	printStructure, err := flags.Bool("print_structure", false)
+	protocTimeout, err := flags.Duration("protoc_timeout", 0)
 	if err != nil {
 		usageAndExit("Error: %v\n", err)
 	}
+	printStructure, printStructureJSON := false, false
+	if flags.String("print_structure", "") == "json" {
+		printStructureJSON = true
+	} else if printStructure, err = flags.Bool("print_structure", false); err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
 	printOnly, err := flags.Bool("print_only", false)
 	if err != nil {
 		usageAndExit("Error: %v\n", err)
 	}
+	if printStructureJSON && (printOnly || errorFormat != "") {
+		usageAndExit("Error: --print_structure=json cannot be used with --print_only or --error_format=json|github\n")
+	}
+	if errorFormat == wrapper.DiagnosticsJSON && (printOnly || printStructure) {
+		usageAndExit("Error: --error_format=json cannot be used with --print_only or --print_structure\n")
+	}
+	force, err := flags.Bool("force", false)
+	if err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	dependencyOrder, err := flags.Bool("dependency_order", false)
+	if err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	watch, err := flags.Bool("watch", false)
+	if err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	keepGoing, err := flags.Bool("keep_going", false)
+	if err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	atomic, err := flags.Bool("atomic", false)
+	if err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	check, err := flags.Bool("check", false)
+	if err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	if check && (watch || printOnly) {
+		usageAndExit("Error: --check cannot be used with --watch or --print_only\n")
+	}
+	clean, cleanDryRun := false, false
+	if flags.String("clean", "") == "dry_run" {
+		clean, cleanDryRun = true, true
+	} else if clean, err = flags.Bool("clean", false); err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	changedFiles, err := repeated.FileList("changed_files")
+	if err != nil {
+		usageAndExit("Error: %v\n", err)
+	}
+	machineOutput := printStructureJSON || errorFormat == wrapper.DiagnosticsJSON
+	logger := newLogger(flags, machineOutput)
+	// report writes the diagnostics for the run, once.
+	reported := false
+	report := func(err error) {
+		if !reported {
+			writeDiagnostics(err, errorFormat)
+			reported = true
+		}
+	}
+
+	if flags.Has("since") {
+		since, err := wrapper.ChangedProtosSince(flags.String("since", ""), importDirs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			report(err)
+			os.Exit(1)
+		}
+		if changedFiles == nil {
+			changedFiles = []string{}
+		}
+		changedFiles = append(changedFiles, since...)
+	}
 
 	w := &wrapper.Wrapper{
-		ProtocCommand: flags.String("protoc_command", "protoc"),
-		ProtocFlags:   protocFlags,
-		ProtoFiles:    protos,
-		ImportDirs:    importDirs,
-		NoExpand:      noExpand,
-		Parallelism:   parallelism,
-		PrintOnly:     printOnly,
-	}
-	err = w.Init()
+		ProtocCommand:   flags.String("protoc_command", "protoc"),
+		ProtocFlags:     protocFlags,
+		ProtoFiles:      protos,
+		ImportDirs:      importDirs,
+		NoExpand:        noExpand,
+		Include:         include,
+		Exclude:         exclude,
+		Parser:          flags.String("parser", wrapper.ParserProtoc),
+		CacheDir:        flags.String("cache_dir", ""),
+		Parallelism:     parallelism,
+		PrintOnly:       printOnly,
+		StampDir:        flags.String("stamp_dir", ""),
+		Force:           force,
+		DependencyOrder: dependencyOrder,
+		Atomic:          atomic,
+		KeepGoing:       keepGoing,
+		ProtocTimeout:   protocTimeout,
+		ChangedFiles:    changedFiles,
+		Logger:          logger,
+	}
+	// On SIGINT or SIGTERM, kill any protoc calls in progress and stop.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = w.InitContext(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		report(err)
 		os.Exit(1)
 	}
 
@@ -107,14 +346,70 @@ func main() {
 	if printStructure {
 		w.PrintStructure(os.Stdout)
 	}
+	if printStructureJSON {
+		if err := w.PrintStructureJSON(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			report(err)
+			os.Exit(1)
+		}
+	}
 
 	if err := w.CheckCycles(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(2)
+		if !watch {
+			report(err)
+			os.Exit(2)
+		}
+	} else if check {
+		if err := w.CheckContext(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			report(err)
+			// Only stale files exit 3, so that it can be told apart
+			// from protoc failing.
+			if _, ok := err.(*wrapper.CheckError); ok {
+				os.Exit(3)
+			}
+			os.Exit(1)
+		}
+	} else if err := w.GenerateContext(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating protos: %v\n", err)
+		report(err)
+		if !watch {
+			os.Exit(1)
+		}
 	}
 
-	if err := w.Generate(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error generating protos: %v\n", err)
-		os.Exit(1)
+	if clean {
+		// Don't touch the output directories when they're not being written.
+		cleanDryRun = cleanDryRun || printOnly || check
+		stale, err := w.Clean(cleanDryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error cleaning stale files: %v\n", err)
+			report(err)
+			os.Exit(1)
+		}
+		for _, file := range stale {
+			if !cleanDryRun {
+				logger.Info("Removed stale file", "file", file)
+				continue
+			}
+			// The listing is the output asked for, so it is printed
+			// rather than logged; to stderr if stdout is taken.
+			if machineOutput {
+				fmt.Fprintln(os.Stderr, file)
+			} else {
+				fmt.Println(file)
+			}
+		}
+	}
+
+	report(nil)
+
+	if watch {
+		logger.Info("Watching for changes...")
+		if err := w.Watch(ctx.Done()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error watching for changes: %v\n", err)
+			os.Exit(1)
+		}
 	}
 }
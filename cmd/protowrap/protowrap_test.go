@@ -0,0 +1,154 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/square/goprotowrap/wrapper"
+	"github.com/square/goprotowrap/wrapper/wrappertest"
+)
+
+// TestMain runs protowrap itself, instead of the tests, if
+// PROTOWRAP_TEST_MAIN is set.
+func TestMain(m *testing.M) {
+	if os.Getenv("PROTOWRAP_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestErrorFormatJSON(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake protoc is a shell script")
+	}
+	tree := wrappertest.NewTree(t).
+		Add("a/a.proto", "ex.com/a", "b/b.proto").
+		Add("b/b.proto", "ex.com/b")
+
+	tests := map[string]struct {
+		protoc      string
+		diagnostics []wrapper.Diagnostic
+	}{
+		"success": {"exit 0", []wrapper.Diagnostic{}},
+		"failure": {
+			"echo 'b/b.proto:3:5: boom' >&2; exit 1",
+			[]wrapper.Diagnostic{{File: "b/b.proto", Line: 3, Column: 5, Severity: "error", Message: "boom", Package: "ex.com/b;b"}},
+		},
+	}
+	for name, tt := range tests {
+		protoc := filepath.Join(t.TempDir(), "protoc")
+		if err := ioutil.WriteFile(protoc, []byte("#!/bin/sh\n"+tt.protoc+"\n"), 0777); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(os.Args[0], "--parser=go", "--parallelism=1", "--error_format=json",
+			"--protoc_command="+protoc, "-I"+tree.Dir, "--go_out="+t.TempDir(), tree.Path("b/b.proto"))
+		cmd.Env = append(os.Environ(), "PROTOWRAP_TEST_MAIN=1")
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		err := cmd.Run()
+		if (err != nil) != (tt.protoc != "exit 0") {
+			t.Errorf("%s: unexpected exit: %v", name, err)
+		}
+
+		var got struct {
+			Diagnostics []wrapper.Diagnostic `json:"diagnostics"`
+		}
+		if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+			t.Errorf("%s: cannot parse stdout %q: %v", name, stdout.String(), err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Diagnostics, tt.diagnostics) {
+			t.Errorf("%s: want diagnostics %+v; got %+v", name, tt.diagnostics, got.Diagnostics)
+		}
+	}
+}
+
+func TestCleanDryRunQuiet(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake protoc is a shell script")
+	}
+	tree := wrappertest.NewTree(t).Add("a/a.proto", "ex.com/a")
+	protoc := filepath.Join(t.TempDir(), "protoc")
+	if err := ioutil.WriteFile(protoc, []byte("#!/bin/sh\nexit 0\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+	stale := filepath.Join(out, "ex.com", "old", "old.pb.go")
+	if err := os.MkdirAll(filepath.Dir(stale), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(stale, []byte("// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage old\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "--parser=go", "--clean=dry_run", "-q",
+		"--protoc_command="+protoc, "-I"+tree.Dir, "--go_out="+out, tree.Path("a/a.proto"))
+	cmd.Env = append(os.Environ(), "PROTOWRAP_TEST_MAIN=1")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := stale + "\n"; stdout.String() != want {
+		t.Errorf("want stdout %q; got %q", want, stdout.String())
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Errorf("want stale file left in place; got %v", err)
+	}
+}
+
+func TestCheckExitStatus(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake protoc is a shell script")
+	}
+	tree := wrappertest.NewTree(t).Add("a/a.proto", "ex.com/a")
+
+	tests := map[string]struct {
+		protoc string
+		status int
+	}{
+		// Generates a.pb.go, which is missing from the output directory.
+		"stale":   {`for f; do case "$f" in --go_out=*) mkdir -p "${f#--go_out=}/a" && echo x > "${f#--go_out=}/a/a.pb.go";; esac; done`, 3},
+		"failure": {"exit 1", 1},
+	}
+	for name, tt := range tests {
+		protoc := filepath.Join(t.TempDir(), "protoc")
+		if err := ioutil.WriteFile(protoc, []byte("#!/bin/sh\n"+tt.protoc+"\n"), 0777); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(os.Args[0], "--parser=go", "--check",
+			"--protoc_command="+protoc, "-I"+tree.Dir, "--go_out="+t.TempDir(), tree.Path("a/a.proto"))
+		cmd.Env = append(os.Environ(), "PROTOWRAP_TEST_MAIN=1")
+		err := cmd.Run()
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Errorf("%s: want exit status %d; got %v", name, tt.status, err)
+			continue
+		}
+		if got := exitErr.ExitCode(); got != tt.status {
+			t.Errorf("%s: want exit status %d; got %d", name, tt.status, got)
+		}
+	}
+}
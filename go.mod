@@ -0,0 +1,10 @@
+module github.com/square/goprotowrap
+
+go 1.21
+
+require (
+	github.com/golang/protobuf v1.5.4
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require google.golang.org/protobuf v1.33.0 // indirect
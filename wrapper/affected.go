@@ -0,0 +1,103 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// affected.go contains the code for working out which packages are
+// affected by changes to a set of files.
+
+package wrapper
+
+import "path/filepath"
+
+// restrictToChanged restricts the packages being generated to those
+// affected by ChangedFiles.
+func (w *Wrapper) restrictToChanged() error {
+	affected, err := w.AffectedPackages(w.ChangedFiles)
+	if err != nil {
+		return err
+	}
+	packages := map[string]*PackageInfo{}
+	for _, name := range affected {
+		if pkg, ok := w.packages[name]; ok {
+			packages[name] = pkg
+		}
+	}
+	w.packages = packages
+	w.sccs = nil
+	return nil
+}
+
+// AffectedPackages returns the sorted names of the packages containing
+// any of the given files, plus every package that transitively imports
+// one of those. Files are matched by path against the .proto files
+// found in this run; other files, such as removed files or files that
+// aren't protos, are ignored.
+func (w *Wrapper) AffectedPackages(files []string) ([]string, error) {
+	byPath := map[string]*FileInfo{}
+	for _, info := range w.infos {
+		if info.FullPath == "" {
+			continue
+		}
+		path, err := filepath.Abs(info.FullPath)
+		if err != nil {
+			return nil, err
+		}
+		byPath[path] = info
+	}
+	names := map[string]bool{}
+	for _, file := range files {
+		path, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		if info, ok := byPath[path]; ok {
+			names[info.ComputedPackage] = true
+		}
+	}
+	dependents := w.dependents(names)
+	result := []string{}
+	for _, pkg := range w.allPackagesInOrder() {
+		if dependents[pkg.ComputedPackage] {
+			result = append(result, pkg.ComputedPackage)
+		}
+	}
+	return result, nil
+}
+
+// dependents returns the named packages, plus every package that
+// transitively imports any of them.
+func (w *Wrapper) dependents(names map[string]bool) map[string]bool {
+	importedBy := map[string][]string{}
+	for _, pkg := range w.allPackagesInOrder() {
+		for _, dep := range pkg.ImportedPackageComputedNames() {
+			importedBy[dep] = append(importedBy[dep], pkg.ComputedPackage)
+		}
+	}
+	result := map[string]bool{}
+	queue := []string{}
+	for name := range names {
+		result[name] = true
+		queue = append(queue, name)
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, dependent := range importedBy[name] {
+			if !result[dependent] {
+				result[dependent] = true
+				queue = append(queue, dependent)
+			}
+		}
+	}
+	return result
+}
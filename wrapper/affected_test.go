@@ -0,0 +1,80 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDependents(t *testing.T) {
+	w := testWrapper(
+		&FileInfo{Name: "a/a.proto", GoPackage: "ex.com/a", Deps: []string{"b/b.proto"}},
+		&FileInfo{Name: "b/b.proto", GoPackage: "ex.com/b", Deps: []string{"c/c.proto"}},
+		&FileInfo{Name: "c/c.proto", GoPackage: "ex.com/c"},
+		&FileInfo{Name: "d/d.proto", GoPackage: "ex.com/d", Deps: []string{"c/c.proto"}},
+		&FileInfo{Name: "e/e.proto", GoPackage: "ex.com/e"},
+	)
+	testCases := map[string]struct {
+		names []string
+		want  map[string]bool
+	}{
+		"leaf": {
+			names: []string{"ex.com/c;c"},
+			want:  map[string]bool{"ex.com/a;a": true, "ex.com/b;b": true, "ex.com/c;c": true, "ex.com/d;d": true},
+		},
+		"middle": {
+			names: []string{"ex.com/b;b"},
+			want:  map[string]bool{"ex.com/a;a": true, "ex.com/b;b": true},
+		},
+		"unimported": {
+			names: []string{"ex.com/a;a", "ex.com/e;e"},
+			want:  map[string]bool{"ex.com/a;a": true, "ex.com/e;e": true},
+		},
+	}
+	for name, tc := range testCases {
+		names := map[string]bool{}
+		for _, n := range tc.names {
+			names[n] = true
+		}
+		if got := w.dependents(names); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: want %v; got %v", name, tc.want, got)
+		}
+	}
+}
+
+func TestAffectedPackages(t *testing.T) {
+	w := testWrapper(
+		&FileInfo{Name: "a/a.proto", GoPackage: "ex.com/a", Deps: []string{"b/b.proto"}},
+		&FileInfo{Name: "b/b.proto", GoPackage: "ex.com/b"},
+		&FileInfo{Name: "b/b2.proto", GoPackage: "ex.com/b"},
+		&FileInfo{Name: "c/c.proto", GoPackage: "ex.com/c"},
+	)
+	got, err := w.AffectedPackages([]string{"./b/b2.proto", "README.md", "gone/gone.proto"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ex.com/a;a", "ex.com/b;b"}; !sliceStringEqual(got, want) {
+		t.Errorf("want %v; got %v", want, got)
+	}
+
+	w.ChangedFiles = []string{"a/a.proto"}
+	if err := w.restrictToChanged(); err != nil {
+		t.Fatal(err)
+	}
+	if structure := w.Structure(); len(structure) != 1 || structure[0].ComputedPackage != "ex.com/a;a" {
+		t.Errorf("want only ex.com/a;a to be generated; got %+v", structure)
+	}
+}
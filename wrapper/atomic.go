@@ -0,0 +1,119 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// atomic.go contains the code for generating into staging directories
+// and moving the results into place.
+
+package wrapper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// stagingPrefix is the prefix of the staging directories created in
+// each output directory. The leading dot keeps the go tool from
+// looking inside them.
+const stagingPrefix = ".protowrap-staging-"
+
+// generateAtomically generates the given packages into a staging
+// directory for each output directory named in the protoc flags, and
+// only if every package succeeds, moves the generated files into
+// place. Files whose contents are unchanged are left untouched. Stamps
+// are only written once the files are in place.
+func (w *Wrapper) generateAtomically(ctx context.Context, packages map[string]*PackageInfo) (err error) {
+	outputs := outputDirs(w.ProtocFlags)
+	if len(outputs) == 0 {
+		return w.generate(ctx, packages, w.ProtocFlags, nil)
+	}
+
+	staging := make([]string, len(outputs))
+	defer func() {
+		for _, dir := range staging {
+			if dir == "" {
+				continue
+			}
+			if err2 := os.RemoveAll(dir); err == nil && err2 != nil {
+				err = err2
+			}
+		}
+	}()
+	for i, output := range outputs {
+		if err := os.MkdirAll(output.dir, 0777); err != nil {
+			return err
+		}
+		if staging[i], err = ioutil.TempDir(output.dir, stagingPrefix); err != nil {
+			return err
+		}
+	}
+
+	stamps := map[*PackageInfo]string{}
+	if err := w.generate(ctx, packages, redirectOutputs(w.ProtocFlags, outputs, staging), stamps); err != nil {
+		return err
+	}
+	for i, output := range outputs {
+		if err := moveChanged(staging[i], output.dir); err != nil {
+			return err
+		}
+	}
+	for pkg, stamp := range stamps {
+		if err := w.writeStamp(pkg, stamp); err != nil {
+			return fmt.Errorf("error writing stamp for package %s: %v\n", pkg.ComputedPackage, err)
+		}
+	}
+	return nil
+}
+
+// moveChanged moves the files below the from directory to the same
+// place below the to directory, except those whose contents are the
+// same as the file they would replace.
+func moveChanged(from, to string) error {
+	return filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+		if same, err := sameContents(path, target); err != nil || same {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return err
+		}
+		return os.Rename(path, target)
+	})
+}
+
+// sameContents returns true if both files exist and have the same
+// contents.
+func sameContents(a, b string) (bool, error) {
+	bData, err := ioutil.ReadFile(b)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	aData, err := ioutil.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(aData, bData), nil
+}
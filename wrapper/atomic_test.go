@@ -0,0 +1,62 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMoveChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomictest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("out/same.pb.go", "same")
+	write("out/changed.pb.go", "old")
+	write("staging/same.pb.go", "same")
+	write("staging/changed.pb.go", "new")
+	write("staging/sub/new.pb.go", "new")
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "out/same.pb.go"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := moveChanged(filepath.Join(dir, "staging"), filepath.Join(dir, "out")); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"same.pb.go": "same", "changed.pb.go": "new", "sub/new.pb.go": "new"} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, "out", name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: want %q; got %q, %v", name, want, got, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, "out/same.pb.go")); err != nil || !info.ModTime().Equal(old) {
+		t.Errorf("want same.pb.go untouched; got %v, %v", info, err)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// baseline.go contains the code for reading and writing baselines of
+// known, accepted package cycles.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// ReadCycleBaseline reads a baseline of accepted cycles, as written by
+// WriteCycleBaseline.
+func ReadCycleBaseline(filename string) ([]Cycle, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	baseline := CycleError{}
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, err
+	}
+	return baseline.Cycles, nil
+}
+
+// WriteCycleBaseline writes the given cycles to a baseline file. The
+// format is the same as the JSON serialization of a CycleError:
+// each cycle's sorted package list, and the edges between them. Any
+// suggestions are dropped.
+func WriteCycleBaseline(filename string, cycles []Cycle) error {
+	baseline := CycleError{Cycles: make([]Cycle, 0, len(cycles))}
+	for _, c := range cycles {
+		c.Suggestion = nil
+		baseline.Cycles = append(baseline.Cycles, c)
+	}
+	data, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0666)
+}
+
+// NewCycles returns the cycles that are not covered by the
+// baseline. A cycle is covered if some baseline cycle contains all its
+// packages and all its package-level edges; new file-level imports
+// along an accepted package-level edge don't make a cycle any worse.
+func NewCycles(cycles, baseline []Cycle) []Cycle {
+	result := []Cycle{}
+	for _, c := range cycles {
+		covered := false
+		for _, b := range baseline {
+			if coveredBy(c, b) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// coveredBy returns true if all of c's packages and package-level
+// edges are in b.
+func coveredBy(c, b Cycle) bool {
+	pkgs := map[string]bool{}
+	for _, pkg := range b.Packages {
+		pkgs[pkg] = true
+	}
+	for _, pkg := range c.Packages {
+		if !pkgs[pkg] {
+			return false
+		}
+	}
+	edges := map[packageEdge]bool{}
+	for _, edge := range b.Edges {
+		edges[packageEdge{edge.From, edge.To}] = true
+	}
+	for _, edge := range c.Edges {
+		if !edges[packageEdge{edge.From, edge.To}] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,202 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// cache.go contains an on-disk cache of FileInfo data, so that
+// repeated runs only need to call protoc for .proto files that have
+// changed.
+
+package wrapper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// cacheVersion is bumped whenever the format of the cache file changes.
+const cacheVersion = 1
+
+// cacheFilename is the name of the cache file within the cache directory.
+const cacheFilename = "fileinfos.json"
+
+// cacheEntry is the cached information for a single .proto file.
+type cacheEntry struct {
+	Hash      string   `json:"hash"` // Content hash of the file, or "" if it wasn't found in the import dirs
+	Package   string   `json:"package,omitempty"`
+	GoPackage string   `json:"go_package,omitempty"`
+	Deps      []string `json:"deps,omitempty"`
+}
+
+// cacheFile is the serialized form of the cache.
+type cacheFile struct {
+	Version int                    `json:"version"`
+	Files   map[string]*cacheEntry `json:"files"`
+}
+
+// FileInfoCache is a cache of the FileInfo data parsed from .proto
+// files, keyed by the content hash of each file and its transitive
+// imports.
+type FileInfoCache struct {
+	Dir     string // The directory the cache is stored in.
+	entries map[string]*cacheEntry
+}
+
+// LoadFileInfoCache loads the cache stored in the given
+// directory. A missing or out-of-date cache file results in an empty
+// cache, not an error.
+func LoadFileInfoCache(dir string) (*FileInfoCache, error) {
+	c := &FileInfoCache{
+		Dir:     dir,
+		entries: map[string]*cacheEntry{},
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, cacheFilename))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cf := cacheFile{}
+	if err := json.Unmarshal(data, &cf); err != nil || cf.Version != cacheVersion {
+		return c, nil
+	}
+	if cf.Files != nil {
+		c.entries = cf.Files
+	}
+	return c, nil
+}
+
+// Lookup returns FileInfos for all the given protos (and their
+// transitive imports) whose cache entries are still valid, and the
+// list of protos that missed. An entry is valid if the file's content
+// hash, and that of each of its transitive imports, is unchanged.
+func (c *FileInfoCache) Lookup(protos []string, importDirs []string) (infos map[string]*FileInfo, misses []string) {
+	infos = map[string]*FileInfo{}
+	valid := map[string]bool{}
+
+	var check func(name, path string) bool
+	check = func(name, path string) bool {
+		if v, ok := valid[name]; ok {
+			return v
+		}
+		// Guard against (invalid) import cycles.
+		valid[name] = false
+		entry, ok := c.entries[name]
+		if !ok {
+			return false
+		}
+		if path == "" {
+			path = findInImportDirs(name, importDirs)
+		}
+		hash, err := hashFile(path)
+		if err != nil || hash != entry.Hash {
+			return false
+		}
+		for _, dep := range entry.Deps {
+			if !check(dep, "") {
+				return false
+			}
+		}
+		valid[name] = true
+		infos[name] = &FileInfo{
+			Name:      name,
+			Package:   entry.Package,
+			GoPackage: entry.GoPackage,
+			Deps:      append([]string(nil), entry.Deps...),
+		}
+		return true
+	}
+
+	for _, proto := range protos {
+		if !check(FileDescriptorName(proto, importDirs), proto) {
+			misses = append(misses, proto)
+		}
+	}
+	return infos, misses
+}
+
+// Update records the given FileInfos in the cache, hashing the
+// corresponding files found in the import directories.
+func (c *FileInfoCache) Update(infos map[string]*FileInfo, importDirs []string) error {
+	for name, info := range infos {
+		hash, err := hashFile(findInImportDirs(name, importDirs))
+		if err != nil {
+			return err
+		}
+		c.entries[name] = &cacheEntry{
+			Hash:      hash,
+			Package:   info.Package,
+			GoPackage: info.GoPackage,
+			Deps:      info.Deps,
+		}
+	}
+	return nil
+}
+
+// Save writes the cache back to its directory, creating it if
+// necessary.
+func (c *FileInfoCache) Save() error {
+	if err := os.MkdirAll(c.Dir, 0777); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cacheFile{Version: cacheVersion, Files: c.entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(c.Dir, cacheFilename+".")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(c.Dir, cacheFilename))
+}
+
+// findInImportDirs returns the path of the file with the given
+// import-path-relative name in the first import directory containing
+// it, or "" if it isn't found (eg. protoc's built-in includes).
+func findInImportDirs(name string, importDirs []string) string {
+	for _, imp := range importDirs {
+		path := filepath.Join(imp, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// hashFile returns the hex-encoded SHA-256 of a file's contents. An
+// empty path hashes to "".
+func hashFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot hash %q: %v", path, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileInfoCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cachetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	cacheDir := filepath.Join(dir, "cache")
+
+	write := func(name, content string) string {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	a := write("a/a.proto", "package a; import \"b/b.proto\";")
+	b := write("b/b.proto", "package b;")
+	c := write("c/c.proto", "package c;")
+	importDirs := []string{src}
+	protos := []string{a, b, c}
+
+	cache, err := LoadFileInfoCache(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infos, misses := cache.Lookup(protos, importDirs); len(infos) != 0 || len(misses) != 3 {
+		t.Fatalf("empty cache: want 0 infos and 3 misses; got %v, %v", infos, misses)
+	}
+
+	err = cache.Update(map[string]*FileInfo{
+		"a/a.proto": {Name: "a/a.proto", Package: "a", Deps: []string{"b/b.proto"}},
+		"b/b.proto": {Name: "b/b.proto", Package: "b"},
+		"c/c.proto": {Name: "c/c.proto", Package: "c", GoPackage: "example.com/c"},
+	}, importDirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = LoadFileInfoCache(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, misses := cache.Lookup(protos, importDirs)
+	if len(infos) != 3 || len(misses) != 0 {
+		t.Fatalf("warm cache: want 3 infos and 0 misses; got %v, %v", infos, misses)
+	}
+	if got := infos["c/c.proto"].GoPackage; got != "example.com/c" {
+		t.Errorf("want GoPackage %q; got %q", "example.com/c", got)
+	}
+
+	// Changing an import invalidates its importers too.
+	write("b/b.proto", "package bb;")
+	infos, misses = cache.Lookup(protos, importDirs)
+	if !sliceStringEqual(misses, []string{a, b}) {
+		t.Errorf("want misses %v; got %v", []string{a, b}, misses)
+	}
+	if _, ok := infos["c/c.proto"]; len(infos) != 1 || !ok {
+		t.Errorf("want only c/c.proto cached; got %v", infos)
+	}
+}
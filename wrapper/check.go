@@ -0,0 +1,221 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// check.go contains the code for checking that generated files in the
+// output directories are up to date.
+
+package wrapper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// outputDir is a protoc flag naming an output directory, such as
+// --go_out=plugins=grpc:gen.
+type outputDir struct {
+	flag   string // The flag name, eg. "--go_out".
+	index  int    // The index of the flag value in the protoc flags.
+	prefix string // The part of the flag value before the directory.
+	params string // The plugin parameters given before the directory, if any.
+	dir    string // The output directory.
+}
+
+// outputDirs returns the output directories named in protoc flags:
+// the values of all --*_out flags, except --descriptor_set_out and
+// --dependency_out, which name files. Parameters before a colon are
+// not part of the directory.
+func outputDirs(protocFlags []string) []outputDir {
+	result := []outputDir{}
+	for i := 0; i < len(protocFlags); i++ {
+		flag := protocFlags[i]
+		if !strings.HasPrefix(flag, "--") || noValueFlags[flag] {
+			continue
+		}
+		parts := strings.SplitN(flag, "=", 2)
+		name := parts[0]
+		valueIndex, prefix := i, name+"="
+		if len(parts) == 1 {
+			valueIndex, prefix = i+1, ""
+			i++
+		}
+		if !strings.HasSuffix(name, "_out") || name == "--descriptor_set_out" || name == "--dependency_out" || valueIndex >= len(protocFlags) {
+			continue
+		}
+		value := strings.TrimPrefix(protocFlags[valueIndex], prefix)
+		params := ""
+		if colon := paramsColon(value); colon >= 0 {
+			params = value[:colon]
+			prefix += value[:colon+1]
+			value = value[colon+1:]
+		}
+		result = append(result, outputDir{flag: name, index: valueIndex, prefix: prefix, params: params, dir: value})
+	}
+	return result
+}
+
+// paramsColon returns the index of the colon separating the plugin
+// parameters from the directory in an output flag value, or -1 if
+// there are no parameters: the first colon, unless it's part of a
+// Windows drive letter, as in C:\out.
+func paramsColon(value string) int {
+	colon := strings.Index(value, ":")
+	if colon == 1 && len(value) > 2 && (value[2] == '\\' || value[2] == '/') {
+		if c := value[0]; 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' {
+			return -1
+		}
+	}
+	return colon
+}
+
+// redirectOutputs returns a copy of the protoc flags with each output
+// directory replaced by the corresponding directory in dirs.
+func redirectOutputs(protocFlags []string, outputs []outputDir, dirs []string) []string {
+	result := append([]string(nil), protocFlags...)
+	for i, output := range outputs {
+		result[output.index] = output.prefix + dirs[i]
+	}
+	return result
+}
+
+// FileDifference describes a generated file that doesn't match the
+// one in the output tree.
+type FileDifference struct {
+	Path    string // The path of the file in the output tree.
+	Summary string // A short description of the difference.
+}
+
+// CheckError is the error returned by Check when generated files are
+// out of date.
+type CheckError struct {
+	Differences []FileDifference
+}
+
+// Error implements the error interface.
+func (e *CheckError) Error() string {
+	result := []string{"generated files are out of date:"}
+	for _, d := range e.Differences {
+		result = append(result, fmt.Sprintf(" %s: %s", d.Path, d.Summary))
+	}
+	return strings.Join(result, "\n") + "\n"
+}
+
+// Check generates every package into a temporary directory, and
+// compares the results byte-for-byte against the files in the output
+// directories named in the protoc flags, leaving them untouched. If
+// any generated file is missing or different, the error returned is a
+// *CheckError. Files in the output directories that wouldn't be
+// generated are not reported.
+func (w *Wrapper) Check() error {
+	return w.CheckContext(context.Background())
+}
+
+// CheckContext is like Check, but stops if the context is done, as
+// with GenerateContext.
+func (w *Wrapper) CheckContext(ctx context.Context) (err error) {
+	if !w.initCalled {
+		return errors.New("Init() must be called before Check()")
+	}
+	outputs := outputDirs(w.ProtocFlags)
+	if len(outputs) == 0 {
+		return errors.New("no output directories (--*_out flags) to check")
+	}
+
+	tmp, err := ioutil.TempDir("", "protowrap-check")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := os.RemoveAll(tmp); err == nil && err2 != nil {
+			err = err2
+		}
+	}()
+
+	dirs := make([]string, len(outputs))
+	for i := range outputs {
+		dirs[i] = filepath.Join(tmp, strconv.Itoa(i))
+		if err := os.MkdirAll(dirs[i], 0777); err != nil {
+			return err
+		}
+	}
+	checker := *w
+	checker.StampDir = ""
+	checker.PrintOnly = false
+	if err := checker.generate(ctx, w.packages, redirectOutputs(w.ProtocFlags, outputs, dirs), nil); err != nil {
+		return err
+	}
+
+	differences := []FileDifference{}
+	for i, output := range outputs {
+		base := dirs[i]
+		err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+			generated, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(output.dir, rel)
+			existing, err := ioutil.ReadFile(target)
+			if os.IsNotExist(err) {
+				differences = append(differences, FileDifference{Path: target, Summary: "missing"})
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if !bytes.Equal(generated, existing) {
+				differences = append(differences, FileDifference{Path: target, Summary: diffSummary(existing, generated)})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(differences) > 0 {
+		return &CheckError{Differences: differences}
+	}
+	return nil
+}
+
+// diffSummary describes how the generated contents of a file differ
+// from its existing contents.
+func diffSummary(existing, generated []byte) string {
+	existingLines := bytes.SplitAfter(existing, []byte("\n"))
+	generatedLines := bytes.SplitAfter(generated, []byte("\n"))
+	first := 0
+	for first < len(existingLines) && first < len(generatedLines) && bytes.Equal(existingLines[first], generatedLines[first]) {
+		first++
+	}
+	last := 0
+	for last < len(existingLines)-first && last < len(generatedLines)-first &&
+		bytes.Equal(existingLines[len(existingLines)-1-last], generatedLines[len(generatedLines)-1-last]) {
+		last++
+	}
+	removed := len(existingLines) - first - last
+	added := len(generatedLines) - first - last
+	return fmt.Sprintf("differs at line %d: %d line(s) would be replaced by %d", first+1, removed, added)
+}
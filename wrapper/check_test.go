@@ -0,0 +1,67 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputDirs(t *testing.T) {
+	testCases := map[string]struct {
+		flags string
+		want  []outputDir
+	}{
+		"simple": {
+			flags: "-I. --go_out=gen",
+			want:  []outputDir{{flag: "--go_out", index: 1, prefix: "--go_out=", dir: "gen"}},
+		},
+		"params": {
+			flags: "--go_out=plugins=grpc,paths=source_relative:out/go --descriptor_set_out=x.pb",
+			want:  []outputDir{{flag: "--go_out", index: 0, prefix: "--go_out=plugins=grpc,paths=source_relative:", params: "plugins=grpc,paths=source_relative", dir: "out/go"}},
+		},
+		"separate value": {
+			flags: "--python_out out/py --dependency_out deps --foo_out=a:b",
+			want: []outputDir{
+				{flag: "--python_out", index: 1, prefix: "", dir: "out/py"},
+				{flag: "--foo_out", index: 4, prefix: "--foo_out=a:", params: "a", dir: "b"},
+			},
+		},
+		"windows drive": {
+			flags: `--go_out=C:\out --foo_out=a=b:D:\out`,
+			want: []outputDir{
+				{flag: "--go_out", index: 0, prefix: "--go_out=", dir: `C:\out`},
+				{flag: "--foo_out", index: 1, prefix: "--foo_out=a=b:", params: "a=b", dir: `D:\out`},
+			},
+		},
+		"no-value flag": {
+			flags: "--include_source_info --go_out=gen",
+			want:  []outputDir{{flag: "--go_out", index: 1, prefix: "--go_out=", dir: "gen"}},
+		},
+	}
+	for name, tc := range testCases {
+		if got := outputDirs(strings.Split(tc.flags, " ")); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: want %+v; got %+v", name, tc.want, got)
+		}
+	}
+}
+
+func TestDiffSummary(t *testing.T) {
+	got := diffSummary([]byte("a\nb\nc\nd\n"), []byte("a\nx\ny\nz\nd\n"))
+	if want := "differs at line 2: 2 line(s) would be replaced by 3"; got != want {
+		t.Errorf("want %q; got %q", want, got)
+	}
+}
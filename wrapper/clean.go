@@ -0,0 +1,223 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// clean.go contains the code for finding and removing stale generated
+// files.
+
+package wrapper
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// generatedHeader is the comment protoc-gen-go puts at the top of the
+// files it generates.
+const generatedHeader = "// Code generated by protoc-gen-go. DO NOT EDIT."
+
+// StaleFiles returns the sorted paths of generated files in the output
+// directories named in the protoc flags that no longer correspond to
+// any .proto file: files ending in ".pb.go" that start with the
+// protoc-gen-go "DO NOT EDIT" header, and aren't where protoc-gen-go
+// would put the output for one of the .proto files in the import
+// directories. Vendor, testdata and hidden directories are skipped.
+func (w *Wrapper) StaleFiles() ([]string, error) {
+	if !w.initCalled {
+		return nil, errors.New("Init() must be called before StaleFiles()")
+	}
+	if w.NoExpand {
+		return nil, errors.New("cannot find stale files when only using the specified files")
+	}
+	infos, err := w.importDirInfos()
+	if err != nil {
+		return nil, err
+	}
+	stale := []string{}
+	for _, output := range outputDirs(w.ProtocFlags) {
+		expected := expectedOutputs(infos, pluginParams(w.ProtocFlags, output))
+		err := filepath.Walk(output.dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && p == output.dir {
+					return nil
+				}
+				return err
+			}
+			name := info.Name()
+			if info.IsDir() {
+				if p != output.dir && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasSuffix(name, ".pb.go") {
+				return nil
+			}
+			rel, err := filepath.Rel(output.dir, p)
+			if err != nil {
+				return err
+			}
+			if expected[filepath.ToSlash(rel)] {
+				return nil
+			}
+			generated, err := hasGeneratedHeader(p)
+			if err != nil {
+				return err
+			}
+			if generated {
+				stale = append(stale, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(stale)
+	return stale, nil
+}
+
+// Clean removes the files returned by StaleFiles, and returns their
+// paths. If dryRun is true, it just returns the paths.
+func (w *Wrapper) Clean(dryRun bool) ([]string, error) {
+	stale, err := w.StaleFiles()
+	if err != nil || dryRun {
+		return stale, err
+	}
+	for _, p := range stale {
+		if err := os.Remove(p); err != nil {
+			return nil, err
+		}
+	}
+	return stale, nil
+}
+
+// importDirInfos returns the FileInfos for every .proto file in the
+// import directories, including those skipped by Include, Exclude and
+// .protowrapignore files, or not otherwise needed: their generated
+// files aren't stale just because they aren't being generated now.
+// Files the Wrapper doesn't already know about are parsed in-process;
+// those that can't be parsed, such as deliberately broken test
+// fixtures, are skipped with a warning.
+func (w *Wrapper) importDirInfos() ([]*FileInfo, error) {
+	unknown := map[string]*FileInfo{}
+	for _, dir := range w.ImportDirs {
+		err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !strings.HasSuffix(info.Name(), ".proto") {
+				return nil
+			}
+			name := FileDescriptorName(p, w.ImportDirs)
+			if _, ok := w.infos[name]; ok {
+				return nil
+			}
+			if _, ok := unknown[name]; ok {
+				return nil
+			}
+			contents, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			parsed, err := ParseFileInfo(name, string(contents))
+			if err != nil {
+				w.logger().Warn("Cannot work out the generated file; ignoring it", "file", p, "error", err)
+				return nil
+			}
+			unknown[name] = parsed
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	computeGoLocations(unknown, w.logger())
+
+	result := make([]*FileInfo, 0, len(w.infos)+len(unknown))
+	for _, info := range w.infos {
+		result = append(result, info)
+	}
+	for _, info := range unknown {
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+// expectedOutputs returns the set of slash-separated paths, relative
+// to the output directory, that protoc-gen-go would generate for the
+// given .proto files, given its parameters.
+func expectedOutputs(infos []*FileInfo, params map[string]string) map[string]bool {
+	expected := map[string]bool{}
+	for _, info := range infos {
+		name := info.GoPluginOutputFilename()
+		if params["paths"] != "source_relative" {
+			name = path.Join(importPath(info.ComputedPackage), path.Base(name))
+			if module := params["module"]; module != "" {
+				name = strings.TrimPrefix(name, module+"/")
+			}
+		}
+		expected[name] = true
+	}
+	return expected
+}
+
+// pluginParams returns the plugin parameters for an output directory:
+// those before the directory in the flag value, and those given in
+// the corresponding --*_opt flags.
+func pluginParams(protocFlags []string, output outputDir) map[string]string {
+	values := []string{output.params}
+	opt := strings.TrimSuffix(output.flag, "_out") + "_opt"
+	for i := 0; i < len(protocFlags); i++ {
+		if protocFlags[i] == opt && i+1 < len(protocFlags) {
+			values = append(values, protocFlags[i+1])
+			i++
+		} else if strings.HasPrefix(protocFlags[i], opt+"=") {
+			values = append(values, strings.TrimPrefix(protocFlags[i], opt+"="))
+		}
+	}
+
+	params := map[string]string{}
+	for _, value := range values {
+		for _, param := range strings.Split(value, ",") {
+			if param == "" {
+				continue
+			}
+			parts := strings.SplitN(param, "=", 2)
+			if len(parts) == 2 {
+				params[parts[0]] = parts[1]
+			} else {
+				params[parts[0]] = ""
+			}
+		}
+	}
+	return params
+}
+
+// hasGeneratedHeader returns true if the file starts with the
+// protoc-gen-go "DO NOT EDIT" header.
+func hasGeneratedHeader(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	return scanner.Scan() && scanner.Text() == generatedHeader, scanner.Err()
+}
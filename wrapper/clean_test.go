@@ -0,0 +1,134 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStaleFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cleantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	header := generatedHeader + "\n\npackage x\n"
+	for name, content := range map[string]string{
+		"ex.com/a/a.pb.go":        header,
+		"ex.com/a/gone.pb.go":     header,
+		"ex.com/a/handwritten.go": "package a\n",
+		"ex.com/a/other.pb.go":    "// Code generated by something else. DO NOT EDIT.\n",
+		"ex.com/old/b.pb.go":      header,
+		"ex.com/b/b.pb.go":        header,
+		"vendor/v/v.pb.go":        header,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := testWrapper(
+		&FileInfo{Name: "a/a.proto", GoPackage: "ex.com/a"},
+		&FileInfo{Name: "b/b.proto", GoPackage: "ex.com/b"},
+	)
+	w.ProtocFlags = []string{"--go_out=plugins=grpc:" + dir}
+	stale, err := w.Clean(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "ex.com/a/gone.pb.go"), filepath.Join(dir, "ex.com/old/b.pb.go")}
+	if !sliceStringEqual(stale, want) {
+		t.Errorf("want stale files %v; got %v", want, stale)
+	}
+	if _, err := os.Stat(want[0]); err != nil {
+		t.Errorf("dry run removed %s: %v", want[0], err)
+	}
+
+	// With source-relative paths, everything under ex.com is stale.
+	w.ProtocFlags = []string{"--go_out=" + dir, "--go_opt=paths=source_relative"}
+	if stale, err = w.Clean(false); err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 4 {
+		t.Errorf("want 4 stale files; got %v", stale)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ex.com/a/a.pb.go")); !os.IsNotExist(err) {
+		t.Errorf("want ex.com/a/a.pb.go removed; got %v", err)
+	}
+}
+
+func TestStaleFilesExcluded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cleantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	header := generatedHeader + "\n\npackage x\n"
+	for name, content := range map[string]string{
+		"protos/a/a.proto":           "syntax = \"proto3\";\npackage a;\noption go_package = \"ex.com/a\";\n",
+		"protos/b/b.proto":           "syntax = \"proto3\";\npackage b;\noption go_package = \"ex.com/b\";\n",
+		"protos/c/c.proto":           "syntax = \"proto3\";\npackage c;\noption go_package = \"ex.com/c\";\n",
+		"protos/c/.protowrapignore":  "*.proto\n",
+		"protos/testdata/bad.proto":  "not a proto\n",
+		"gen/ex.com/a/a.pb.go":       header,
+		"gen/ex.com/b/b.pb.go":       header,
+		"gen/ex.com/c/c.pb.go":       header,
+		"gen/ex.com/gone/gone.pb.go": header,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// b is excluded, and c ignored, but their generated files aren't
+	// stale. testdata/bad.proto can't be parsed, and is skipped.
+	var log bytes.Buffer
+	w := &Wrapper{
+		ImportDirs:  []string{filepath.Join(dir, "protos")},
+		ProtoFiles:  []string{filepath.Join(dir, "protos/a/a.proto")},
+		ProtocFlags: []string{"--go_out=" + filepath.Join(dir, "gen")},
+		Exclude:     []string{"b", "testdata"},
+		Parser:      ParserGo,
+		Parallelism: 1,
+		Logger:      NewLogger(&log, &log, slog.LevelInfo),
+	}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := w.StaleFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "gen/ex.com/gone/gone.pb.go")}; !sliceStringEqual(stale, want) {
+		t.Errorf("want stale files %v; got %v", want, stale)
+	}
+	if !strings.Contains(log.String(), "bad.proto") {
+		t.Errorf("want a warning about bad.proto; got %q", log.String())
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// config.go contains the code to find and read project config files.
+
+package wrapper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ConfigFilename is the name of the project config file, searched for
+// in the working directory and its ancestors.
+const ConfigFilename = "protowrap.yaml"
+
+// Config is the contents of a project config file. Relative import
+// directories, including those given with -I in protoc_flags, and
+// relative output directories are relative to the directory
+// containing the file.
+type Config struct {
+	ImportDirs    []string `yaml:"import_dirs"`    // Import directories, as with -I
+	ProtocCommand string   `yaml:"protoc_command"` // As with --protoc_command
+	Parallelism   int      `yaml:"parallelism"`    // As with --parallelism
+	ProtocFlags   []string `yaml:"protoc_flags"`   // Flags to pass through to protoc, eg. --go_out
+	Include       []string `yaml:"include"`        // Globs of .proto files to use; see Wrapper.Include
+	Exclude       []string `yaml:"exclude"`        // Globs of .proto files to ignore; see Wrapper.Exclude
+
+	dir string // The directory containing the config file.
+}
+
+// FindConfig looks for a project config file in dir and each of its
+// ancestors, returning the filename of the first one found, or "" if
+// there is none.
+func FindConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		filename := filepath.Join(dir, ConfigFilename)
+		if _, err := os.Stat(filename); err == nil {
+			return filename, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// LoadConfig reads a project config file.
+func LoadConfig(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if c.dir, err = filepath.Abs(filepath.Dir(filename)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadConfigForFlags loads the project config file named by the
+// "config" flag, or if that isn't set, the one found by FindConfig
+// starting at the working directory. It returns nil if there is no
+// config file.
+func LoadConfigForFlags(flags FlagValues) (*Config, error) {
+	filename := flags.String("config", "")
+	if filename == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		if filename, err = FindConfig(wd); err != nil || filename == "" {
+			return nil, err
+		}
+	}
+	return LoadConfig(filename)
+}
+
+// Merge merges the config into parsed commandline arguments, as
+// returned by ParseArgs. Anything given on the commandline overrides
+// the config file: protoc_command and parallelism are only used if the
+// corresponding flags weren't given, import_dirs only if no -I flags
+// were given, and each of the protoc_flags only if no flag of the same
+// name was given: --go_out on the commandline replaces the config's
+// --go_out (and every --go_opt replaces all the config's --go_opt
+// flags), but leaves its --grpc_out alone.
+func (c *Config) Merge(flags FlagValues, protocFlags, importDirs []string) (newProtocFlags, newImportDirs []string, err error) {
+	if c.ProtocCommand != "" && !flags.Has("protoc_command") {
+		flags["protoc_command"] = c.ProtocCommand
+	}
+	if c.Parallelism != 0 && !flags.Has("parallelism") {
+		flags["parallelism"] = strconv.Itoa(c.Parallelism)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, nil, err
+	}
+	var extra []string
+	if len(importDirs) == 0 {
+		for _, dir := range c.ImportDirs {
+			if dir, err = c.rebase(wd, dir); err != nil {
+				return nil, nil, err
+			}
+			importDirs = append(importDirs, dir)
+			extra = append(extra, "-I"+dir)
+		}
+	}
+	configFlags, err := c.rebaseProtocFlags(wd)
+	if err != nil {
+		return nil, nil, err
+	}
+	given := map[string]bool{}
+	for _, flag := range splitProtocFlags(protocFlags) {
+		given[protocFlagName(flag[0])] = true
+	}
+	for _, flag := range splitProtocFlags(configFlags) {
+		name := protocFlagName(flag[0])
+		if given[name] {
+			continue
+		}
+		extra = append(extra, flag...)
+		if name == "-I" {
+			importDirs = append(importDirs, strings.Split(importDirValue(flag), string(os.PathListSeparator))...)
+		}
+	}
+	return append(extra, protocFlags...), importDirs, nil
+}
+
+// rebase returns dir, which is relative to the directory containing
+// the config file, relative to the working directory wd instead.
+func (c *Config) rebase(wd, dir string) (string, error) {
+	if filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	return filepath.Rel(wd, filepath.Join(c.dir, dir))
+}
+
+// rebaseProtocFlags returns a copy of the config's protoc flags, with
+// relative import and output directories made relative to the working
+// directory wd.
+func (c *Config) rebaseProtocFlags(wd string) ([]string, error) {
+	outputs := outputDirs(c.ProtocFlags)
+	dirs := make([]string, len(outputs))
+	for i, output := range outputs {
+		dir, err := c.rebase(wd, output.dir)
+		if err != nil {
+			return nil, err
+		}
+		dirs[i] = dir
+	}
+	result := redirectOutputs(c.ProtocFlags, outputs, dirs)
+
+	for _, flag := range splitProtocFlags(result) {
+		if protocFlagName(flag[0]) != "-I" {
+			continue
+		}
+		rebased := []string{}
+		for _, dir := range strings.Split(importDirValue(flag), string(os.PathListSeparator)) {
+			dir, err := c.rebase(wd, dir)
+			if err != nil {
+				return nil, err
+			}
+			rebased = append(rebased, dir)
+		}
+		value := strings.Join(rebased, string(os.PathListSeparator))
+		// flag shares result's storage, so this updates result.
+		if len(flag) == 2 {
+			flag[1] = value
+		} else {
+			flag[0] = "-I" + value
+		}
+	}
+	return result, nil
+}
+
+// importDirValue returns the value of a -I flag, as split by
+// splitProtocFlags: either "-Idir" or "-I", "dir".
+func importDirValue(flag []string) string {
+	if len(flag) == 2 {
+		return flag[1]
+	}
+	return flag[0][2:]
+}
+
+// splitProtocFlags splits protoc flags into single flags, each
+// followed by its separate value, if it has one.
+func splitProtocFlags(protocFlags []string) [][]string {
+	result := [][]string{}
+	for i := 0; i < len(protocFlags); i++ {
+		flag := protocFlags[i]
+		takesNext := !noValueFlags[flag] && i+1 < len(protocFlags) &&
+			((strings.HasPrefix(flag, "--") && !strings.Contains(flag, "=")) ||
+				(!strings.HasPrefix(flag, "--") && len(flag) == 2))
+		if takesNext {
+			result = append(result, protocFlags[i:i+2])
+			i++
+			continue
+		}
+		result = append(result, protocFlags[i:i+1])
+	}
+	return result
+}
+
+// protocFlagName returns the name of a protoc flag: "--go_out" for
+// "--go_out=gen", or "-I" for "-Iprotos".
+func protocFlagName(flag string) string {
+	if strings.HasPrefix(flag, "--") {
+		return strings.SplitN(flag, "=", 2)[0]
+	}
+	if len(flag) > 2 {
+		return flag[:2]
+	}
+	return flag
+}
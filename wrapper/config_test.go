@@ -0,0 +1,111 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sub := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(sub, 0777); err != nil {
+		t.Fatal(err)
+	}
+	config := `
+import_dirs: [protos]
+protoc_command: /opt/protoc
+parallelism: 3
+protoc_flags: ["--go_out=out", "--go_opt=paths=source_relative", "-Ithird_party"]
+exclude: [testdata]
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, ConfigFilename), []byte(config), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	filename, err := FindConfig(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sliceStringEqual(c.Exclude, []string{"testdata"}) {
+		t.Errorf("want exclude [testdata]; got %v", c.Exclude)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	tests := map[string]struct {
+		wd          string // Relative to the config file's directory.
+		args        string
+		protocFlags []string
+		importDirs  []string
+		parallelism string
+	}{
+		"config only": {".", "foo.proto", []string{"-Iprotos", "--go_out=out", "--go_opt=paths=source_relative", "-Ithird_party"}, []string{"protos", "third_party"}, "3"},
+		"subdirectory": {
+			"a/b", "foo.proto",
+			[]string{"-I../../protos", "--go_out=../../out", "--go_opt=paths=source_relative", "-I../../third_party"}, []string{"../../protos", "../../third_party"}, "3",
+		},
+		"override": {
+			".", "-I other --foo_out=x --parallelism=7 foo.proto",
+			[]string{"--go_out=out", "--go_opt=paths=source_relative", "-I", "other", "--foo_out=x"}, []string{"other"}, "7",
+		},
+		"override one flag": {
+			".", "--go_out mine --include_source_info foo.proto",
+			[]string{"-Iprotos", "--go_opt=paths=source_relative", "-Ithird_party", "--go_out", "mine", "--include_source_info"}, []string{"protos", "third_party"}, "3",
+		},
+		"import dirs only": {".", "-Iother foo.proto", []string{"--go_out=out", "--go_opt=paths=source_relative", "-Iother"}, []string{"other"}, "3"},
+	}
+	for name, tt := range tests {
+		if err := os.Chdir(filepath.Join(dir, tt.wd)); err != nil {
+			t.Fatal(err)
+		}
+		flags, protocFlags, _, importDirs, err := ParseArgs(strings.Split(tt.args, " "), map[string]bool{"parallelism": true})
+		if err != nil {
+			t.Fatalf("%q: %v", name, err)
+		}
+		protocFlags, importDirs, err = c.Merge(flags, protocFlags, importDirs)
+		if err != nil {
+			t.Fatalf("%q: %v", name, err)
+		}
+		if !sliceStringEqual(protocFlags, tt.protocFlags) {
+			t.Errorf("%q: want protocFlags=%v; got %v", name, tt.protocFlags, protocFlags)
+		}
+		if !sliceStringEqual(importDirs, tt.importDirs) {
+			t.Errorf("%q: want importDirs=%v; got %v", name, tt.importDirs, importDirs)
+		}
+		if got := flags.String("parallelism", ""); got != tt.parallelism {
+			t.Errorf("%q: want parallelism=%q; got %q", name, tt.parallelism, got)
+		}
+		if got := flags.String("protoc_command", ""); got != "/opt/protoc" {
+			t.Errorf("%q: want protoc_command=%q; got %q", name, "/opt/protoc", got)
+		}
+	}
+}
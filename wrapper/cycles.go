@@ -16,39 +16,126 @@ package wrapper
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
+// FileImport is a single import of one .proto file by another.
+type FileImport struct {
+	From string `json:"from"` // The importing file (import-path-relative)
+	To   string `json:"to"`   // The imported file (import-path-relative)
+}
+
+// PackageImport is an import of one Go package by another, with the
+// file-level imports that cause it.
+type PackageImport struct {
+	From    string       `json:"from"`    // The ComputedPackage of the importing package
+	To      string       `json:"to"`      // The ComputedPackage of the imported package
+	Imports []FileImport `json:"imports"` // The file-level imports responsible
+}
+
+// Cycle describes a single strongly-connected component of the
+// package graph: a set of packages that (transitively) import each
+// other.
+type Cycle struct {
+	Packages []string        `json:"packages"` // The ComputedPackages in the cycle, sorted
+	Edges    []PackageImport `json:"edges"`    // The imports between packages in the cycle, sorted
+
+	// Ways to break the cycle. Only set by SuggestCycleBreaks.
+	Suggestion *CycleSuggestion `json:"suggestion,omitempty"`
+}
+
+// String returns a human-readable description of why the cycle's
+// packages are strongly connected.
+func (c Cycle) String() string {
+	result := []string{}
+	for _, edge := range c.Edges {
+		result = append(result, fmt.Sprintf(" %s --> %s", edge.From, edge.To))
+		for _, imp := range edge.Imports {
+			result = append(result, fmt.Sprintf("  %s imports %s", imp.From, imp.To))
+		}
+	}
+	if c.Suggestion != nil {
+		result = append(result, c.Suggestion.String())
+	}
+	return strings.Join(result, "\n")
+}
+
+// CycleError is the error returned by CheckCycles when proto imports
+// would result in Go package cycles.
+type CycleError struct {
+	Cycles []Cycle `json:"cycles"`
+}
+
+// Error implements the error interface.
+func (e *CycleError) Error() string {
+	cycles := []string{}
+	for _, c := range e.Cycles {
+		cycles = append(cycles, c.String())
+	}
+	return fmt.Sprintf("cycles found:\n%s\n", strings.Join(cycles, "\n"))
+}
+
 // CheckCycles checks for proto import structures that would result in
-// Go package cycles.
+// Go package cycles. If there are any, the error returned is a
+// *CycleError.
 func (w *Wrapper) CheckCycles() error {
-	w.sccs = w.tarjan()
-	cycles := []string{}
-	for _, scc := range w.sccs {
+	cycles := w.Cycles()
+	if len(cycles) > 0 {
+		return &CycleError{Cycles: cycles}
+	}
+	return nil
+}
+
+// Cycles returns the cycles in the package graph, sorted by their
+// first package.
+func (w *Wrapper) Cycles() []Cycle {
+	cycles := []Cycle{}
+	for _, scc := range w.components() {
 		if len(scc) > 1 {
-			cycles = append(cycles, w.showComponent(scc))
+			cycles = append(cycles, w.cycle(scc))
 		}
 	}
-	if len(cycles) > 0 {
-		return fmt.Errorf("cycles found:\n%s\n", strings.Join(cycles, "\n"))
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i].Packages[0] < cycles[j].Packages[0] })
+	return cycles
+}
+
+// components returns the strongly-connected components of the package
+// graph, computing them on first use.
+func (w *Wrapper) components() [][]*PackageInfo {
+	if w.sccs == nil {
+		w.sccs = w.tarjan()
 	}
-	return nil
+	return w.sccs
+}
+
+// tarjanNode holds Tarjan's algorithm's bookkeeping for a single
+// package.
+type tarjanNode struct {
+	index   int
+	lowlink int
+	onStack bool
 }
 
 // https://en.wikipedia.org/wiki/Tarjan%27s_SCC_algorithm
+//
+// The bookkeeping is kept in a local map rather than on the
+// PackageInfo values, since those are shared with copies of the
+// Wrapper (see CheckContext).
 func (wr *Wrapper) tarjan() [][]*PackageInfo {
 	index := 1
 	sccs := [][]*PackageInfo{}
 	s := []*PackageInfo{}
+	nodes := map[*PackageInfo]*tarjanNode{}
 
 	var strongConnect func(*PackageInfo)
 	strongConnect = func(v *PackageInfo) {
 		// Set the depth index for v to the smallest unused index.
-		v.index = index
-		v.lowlink = index
+		vn := &tarjanNode{index: index, lowlink: index}
+		nodes[v] = vn
 		index++
 		s = append(s, v)
-		v.onStack = true
+		vn.onStack = true
 
 		// Consider successors of v.
 		for _, wName := range v.ImportedPackageComputedNames() {
@@ -56,30 +143,31 @@ func (wr *Wrapper) tarjan() [][]*PackageInfo {
 			if !ok {
 				panic(fmt.Sprintf("%q not found in %v", wName, wr.allPackages))
 			}
-			if w.index == 0 {
+			wn, visited := nodes[w]
+			if !visited {
 				// Successor w has not yet been visited; recurse on it
 				strongConnect(w)
-				if w.lowlink < v.lowlink {
-					v.lowlink = w.lowlink
+				if wn = nodes[w]; wn.lowlink < vn.lowlink {
+					vn.lowlink = wn.lowlink
 				}
 			} else {
-				if w.onStack {
+				if wn.onStack {
 					// Successor w is in stack s and hence in the current SCC
-					if w.index < v.lowlink {
-						v.lowlink = w.index
+					if wn.index < vn.lowlink {
+						vn.lowlink = wn.index
 					}
 				}
 			}
 		}
 
 		// If v is a root node, pop the stack and generate an SCC
-		if v.lowlink == v.index {
+		if vn.lowlink == vn.index {
 			scc := []*PackageInfo{}
 			var w *PackageInfo
 			for w != v {
 				w = s[len(s)-1]
 				s = s[:len(s)-1]
-				w.onStack = false
+				nodes[w].onStack = false
 				scc = append(scc, w)
 			}
 			sccs = append(sccs, scc)
@@ -87,7 +175,7 @@ func (wr *Wrapper) tarjan() [][]*PackageInfo {
 	}
 
 	for _, pkg := range wr.packagesInOrder() {
-		if pkg.index == 0 {
+		if _, visited := nodes[pkg]; !visited {
 			strongConnect(pkg)
 		}
 	}
@@ -95,28 +183,50 @@ func (wr *Wrapper) tarjan() [][]*PackageInfo {
 	return sccs
 }
 
-// showComponent returns a string describing why a strongly connected
+// cycle returns a Cycle describing why a strongly connected
 // component is strongly connected.
-func (w *Wrapper) showComponent(pkgs []*PackageInfo) string {
-	result := []string{}
+func (w *Wrapper) cycle(pkgs []*PackageInfo) Cycle {
+	c := Cycle{}
 	inCycle := map[string]bool{}
 	for _, pkg := range pkgs {
 		inCycle[pkg.ComputedPackage] = true
+		c.Packages = append(c.Packages, pkg.ComputedPackage)
 	}
-	for _, pkg := range pkgs {
-		for _, other := range pkg.ImportedPackageComputedNames() {
+	sort.Strings(c.Packages)
+	for _, name := range c.Packages {
+		pkg := w.allPackages[name]
+		imported := pkg.ImportedPackageComputedNames()
+		sort.Strings(imported)
+		for _, other := range imported {
 			if inCycle[other] {
-				result = append(result, fmt.Sprintf(" %s --> %s", pkg.ComputedPackage, other))
-				for _, f := range pkg.Files {
-					for _, depName := range f.Deps {
-						dep := w.infos[depName]
-						if dep.ComputedPackage == other {
-							result = append(result, fmt.Sprintf("  %s imports %s", f.Name, dep.Name))
-						}
-					}
-				}
+				c.Edges = append(c.Edges, PackageImport{
+					From:    name,
+					To:      other,
+					Imports: w.fileImports(pkg, other),
+				})
 			}
 		}
 	}
-	return strings.Join(result, "\n")
+	return c
+}
+
+// fileImports returns the file-level imports that cause pkg to import
+// the package named other.
+func (w *Wrapper) fileImports(pkg *PackageInfo, other string) []FileImport {
+	result := []FileImport{}
+	for _, f := range pkg.Files {
+		for _, depName := range f.Deps {
+			dep := w.infos[depName]
+			if dep.ComputedPackage == other {
+				result = append(result, FileImport{From: f.Name, To: dep.Name})
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].From != result[j].From {
+			return result[i].From < result[j].From
+		}
+		return result[i].To < result[j].To
+	})
+	return result
 }
@@ -0,0 +1,131 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckCycles(t *testing.T) {
+	w := testWrapper(
+		&FileInfo{Name: "a/a.proto", GoPackage: "ex.com/a", Deps: []string{"b/b.proto"}},
+		&FileInfo{Name: "b/b.proto", GoPackage: "ex.com/b", Deps: []string{"c/c.proto"}},
+		&FileInfo{Name: "b/b2.proto", GoPackage: "ex.com/b", Deps: []string{"a/a.proto"}},
+		&FileInfo{Name: "c/c.proto", GoPackage: "ex.com/c"},
+	)
+	err := w.CheckCycles()
+	cycleErr, ok := err.(*CycleError)
+	if !ok {
+		t.Fatalf("want *CycleError; got %v", err)
+	}
+	want := []Cycle{{
+		Packages: []string{"ex.com/a;a", "ex.com/b;b"},
+		Edges: []PackageImport{
+			{From: "ex.com/a;a", To: "ex.com/b;b", Imports: []FileImport{{From: "a/a.proto", To: "b/b.proto"}}},
+			{From: "ex.com/b;b", To: "ex.com/a;a", Imports: []FileImport{{From: "b/b2.proto", To: "a/a.proto"}}},
+		},
+	}}
+	if !reflect.DeepEqual(cycleErr.Cycles, want) {
+		t.Errorf("want cycles %+v; got %+v", want, cycleErr.Cycles)
+	}
+
+	w = testWrapper(
+		&FileInfo{Name: "a/a.proto", GoPackage: "ex.com/a", Deps: []string{"b/b.proto"}},
+		&FileInfo{Name: "b/b.proto", GoPackage: "ex.com/b"},
+	)
+	if err := w.CheckCycles(); err != nil {
+		t.Errorf("want no error; got %v", err)
+	}
+}
+
+func TestSuggestCycleBreaks(t *testing.T) {
+	w := testWrapper(
+		&FileInfo{Name: "a/a.proto", GoPackage: "ex.com/a", Deps: []string{"b/b.proto"}},
+		&FileInfo{Name: "a/a2.proto", GoPackage: "ex.com/a", Deps: []string{"b/b.proto"}},
+		&FileInfo{Name: "b/b.proto", GoPackage: "ex.com/b", Deps: []string{"c/c.proto"}},
+		&FileInfo{Name: "b/b2.proto", GoPackage: "ex.com/b", Deps: []string{"a/a.proto"}},
+		&FileInfo{Name: "c/c.proto", GoPackage: "ex.com/c"},
+	)
+	cycleErr, ok := w.CheckCycles().(*CycleError)
+	if !ok {
+		t.Fatal("want *CycleError")
+	}
+	w.SuggestCycleBreaks(cycleErr)
+	got := cycleErr.Cycles[0].Suggestion
+	want := &CycleSuggestion{
+		// b -> a has one file-level import; a -> b has two.
+		RemoveImports: []FileImport{{From: "b/b2.proto", To: "a/a.proto"}},
+		MovableFiles:  []string{"b/b.proto", "b/b2.proto"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want suggestion %+v; got %+v", want, got)
+	}
+}
+
+func TestFeedbackArcSet(t *testing.T) {
+	// Two cycles sharing the heavy edge b -> c: a -> b -> c -> a and
+	// b -> c -> d -> b.
+	weights := map[packageEdge]int{
+		{"a", "b"}: 2,
+		{"b", "c"}: 1,
+		{"c", "a"}: 3,
+		{"c", "d"}: 2,
+		{"d", "b"}: 2,
+	}
+	got := feedbackArcSet([]string{"a", "b", "c", "d"}, weights)
+	want := []packageEdge{{"b", "c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v; got %v", want, got)
+	}
+}
+
+func TestNewCycles(t *testing.T) {
+	edge := func(from, to string) PackageImport { return PackageImport{From: from, To: to} }
+	baseline := []Cycle{{
+		Packages: []string{"a", "b", "c"},
+		Edges:    []PackageImport{edge("a", "b"), edge("b", "c"), edge("c", "a")},
+	}}
+
+	tests := map[string]struct {
+		cycle Cycle
+		isNew bool
+	}{
+		"same": {baseline[0], false},
+		"new edge": {Cycle{
+			Packages: []string{"a", "b"},
+			Edges:    []PackageImport{edge("a", "b"), edge("b", "a")},
+		}, true},
+		"subset": {Cycle{
+			Packages: []string{"a", "b", "c"},
+			Edges:    []PackageImport{edge("b", "c"), edge("c", "a")},
+		}, false},
+		"more packages": {Cycle{
+			Packages: []string{"a", "b", "c", "d"},
+			Edges:    []PackageImport{edge("a", "b"), edge("b", "c"), edge("c", "d"), edge("d", "a")},
+		}, true},
+		"unrelated": {Cycle{
+			Packages: []string{"x", "y"},
+			Edges:    []PackageImport{edge("x", "y"), edge("y", "x")},
+		}, true},
+	}
+
+	for name, tt := range tests {
+		got := NewCycles([]Cycle{tt.cycle}, baseline)
+		if isNew := len(got) > 0; isNew != tt.isNew {
+			t.Errorf("%q: want new=%v; got %v", name, tt.isNew, isNew)
+		}
+	}
+}
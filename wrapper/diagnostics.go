@@ -0,0 +1,185 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// diagnostics.go contains the code for parsing protoc's error output
+// into structured diagnostics, and printing them.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Output formats for WriteDiagnostics.
+const (
+	DiagnosticsJSON   = "json"   // A JSON document.
+	DiagnosticsGitHub = "github" // GitHub Actions workflow commands.
+)
+
+// Diagnostic is a single error or warning reported by protoc, or one
+// of its plugins.
+type Diagnostic struct {
+	File     string `json:"file,omitempty"`   // The .proto file, as protoc named it, if known.
+	Line     int    `json:"line,omitempty"`   // The 1-based line, if known.
+	Column   int    `json:"column,omitempty"` // The 1-based column, if known.
+	Severity string `json:"severity"`         // "error" or "warning".
+	Message  string `json:"message"`
+	Package  string `json:"package,omitempty"` // The ComputedPackage being generated, if any.
+}
+
+// String returns the diagnostic in protoc's own format.
+func (d Diagnostic) String() string {
+	prefix := ""
+	if d.File != "" {
+		prefix = d.File + ":"
+		if d.Line > 0 {
+			prefix += strconv.Itoa(d.Line) + ":"
+			if d.Column > 0 {
+				prefix += strconv.Itoa(d.Column) + ":"
+			}
+		}
+		prefix += " "
+	}
+	if d.Severity == "warning" {
+		prefix += "warning: "
+	}
+	return prefix + d.Message
+}
+
+// ProtocError is the error returned when a protoc invocation fails.
+type ProtocError struct {
+	Command     string       // The commandline run.
+	Err         error        // The error from running the command.
+	Output      []byte       // The combined stdout and stderr of the command.
+	Diagnostics []Diagnostic // The diagnostics parsed from the output.
+}
+
+// Error implements the error interface.
+func (e *ProtocError) Error() string {
+	return fmt.Sprintf("error running %v\n%v\nOutput:\n======\n%s======\n", e.Command, e.Err, e.Output)
+}
+
+// newProtocError returns a ProtocError for a failed protoc run,
+// attributing its diagnostics to the given package, if any.
+func newProtocError(protocCommand string, args []string, err error, output []byte, pkg string) *ProtocError {
+	return &ProtocError{
+		Command:     fmt.Sprintf("%s %s\n", protocCommand, strings.Join(args, " ")),
+		Err:         err,
+		Output:      output,
+		Diagnostics: ParseDiagnostics(output, pkg),
+	}
+}
+
+// diagnosticRe matches protoc's (default, gcc-style) diagnostics:
+// "file:line:column: message", "file:line: message" and "file:
+// message", where message may start with "warning: ".
+var diagnosticRe = regexp.MustCompile(`^([^:\s][^:]*\.proto)(?::(\d+))?(?::(\d+))?: (warning: )?(.*)$`)
+
+// ParseDiagnostics parses protoc's output into diagnostics, attributed
+// to the given package. Lines that don't name a .proto file, such as
+// plugin failures, become diagnostics with just a message.
+func ParseDiagnostics(output []byte, pkg string) []Diagnostic {
+	result := []Diagnostic{}
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		d := Diagnostic{Severity: "error", Message: line, Package: pkg}
+		if m := diagnosticRe.FindStringSubmatch(line); m != nil {
+			d.File = m[1]
+			d.Line, _ = strconv.Atoi(m[2])
+			d.Column, _ = strconv.Atoi(m[3])
+			if m[4] != "" {
+				d.Severity = "warning"
+			}
+			d.Message = m[5]
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+// Diagnostics returns all the diagnostics carried by an error
+// returned from Init or Generate.
+func Diagnostics(err error) []Diagnostic {
+	var genErr *GenerateError
+	if errors.As(err, &genErr) {
+		result := []Diagnostic{}
+		for _, e := range genErr.Errors {
+			result = append(result, Diagnostics(e)...)
+		}
+		return result
+	}
+	var protocErr *ProtocError
+	if errors.As(err, &protocErr) {
+		return protocErr.Diagnostics
+	}
+	return nil
+}
+
+// WriteDiagnostics writes diagnostics to the given io.Writer in the
+// given format: DiagnosticsJSON or DiagnosticsGitHub.
+func WriteDiagnostics(writer io.Writer, diagnostics []Diagnostic, format string) error {
+	switch format {
+	case DiagnosticsJSON:
+		if diagnostics == nil {
+			diagnostics = []Diagnostic{}
+		}
+		enc := json.NewEncoder(writer)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Diagnostics []Diagnostic `json:"diagnostics"`
+		}{diagnostics})
+	case DiagnosticsGitHub:
+		for _, d := range diagnostics {
+			if _, err := fmt.Fprintln(writer, githubAnnotation(d)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown diagnostics format %q; want %q or %q", format, DiagnosticsJSON, DiagnosticsGitHub)
+}
+
+// githubAnnotation formats a diagnostic as a GitHub Actions workflow
+// command, such as "::error file=a.proto,line=3,col=5::message".
+func githubAnnotation(d Diagnostic) string {
+	escapeData := strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	escapeProperty := strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+	properties := []string{}
+	if d.File != "" {
+		properties = append(properties, "file="+escapeProperty.Replace(d.File))
+	}
+	if d.Line > 0 {
+		properties = append(properties, "line="+strconv.Itoa(d.Line))
+	}
+	if d.Column > 0 {
+		properties = append(properties, "col="+strconv.Itoa(d.Column))
+	}
+	if d.Package != "" {
+		properties = append(properties, "title="+escapeProperty.Replace(d.Package))
+	}
+	command := "::" + d.Severity
+	if len(properties) > 0 {
+		command += " " + strings.Join(properties, ",")
+	}
+	return command + "::" + escapeData.Replace(d.Message)
+}
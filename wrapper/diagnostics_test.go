@@ -0,0 +1,69 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	output := `a/a.proto:12:5: "Foo" is not defined.
+a/a.proto:3:1: warning: Import b/b.proto is unused.
+c/c.proto: File not found.
+--go_out: protoc-gen-go: Plugin failed with status code 1.
+`
+	want := []Diagnostic{
+		{File: "a/a.proto", Line: 12, Column: 5, Severity: "error", Message: `"Foo" is not defined.`, Package: "ex.com/a;a"},
+		{File: "a/a.proto", Line: 3, Column: 1, Severity: "warning", Message: "Import b/b.proto is unused.", Package: "ex.com/a;a"},
+		{File: "c/c.proto", Severity: "error", Message: "File not found.", Package: "ex.com/a;a"},
+		{Severity: "error", Message: "--go_out: protoc-gen-go: Plugin failed with status code 1.", Package: "ex.com/a;a"},
+	}
+	got := ParseDiagnostics([]byte(output), "ex.com/a;a")
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %+v; got %+v", want, got)
+	}
+	for i, line := range []string{
+		`a/a.proto:12:5: "Foo" is not defined.`,
+		"a/a.proto:3:1: warning: Import b/b.proto is unused.",
+		"c/c.proto: File not found.",
+	} {
+		if got[i].String() != line {
+			t.Errorf("want %q; got %q", line, got[i].String())
+		}
+	}
+
+	// Diagnostics are found through the errors Generate returns.
+	err := &GenerateError{Errors: []*PackageError{{
+		Package: "ex.com/a;a",
+		Err:     fmt.Errorf("error generating package: %w", &ProtocError{Err: errors.New("exit status 1"), Diagnostics: want[:1]}),
+	}}}
+	if got := Diagnostics(err); !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("want %+v; got %+v", want[:1], got)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteDiagnostics(&buf, want[1:3], DiagnosticsGitHub); err != nil {
+		t.Fatal(err)
+	}
+	wantGitHub := "::warning file=a/a.proto,line=3,col=1,title=ex.com/a;a::Import b/b.proto is unused.\n" +
+		"::error file=c/c.proto,title=ex.com/a;a::File not found.\n"
+	if buf.String() != wantGitHub {
+		t.Errorf("want %q; got %q", wantGitHub, buf.String())
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// exec.go contains the code for running protoc.
+
+package wrapper
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// ProtocOptions control how GenerateContext and GetFileInfosContext
+// run protoc. The zero value runs it locally, with no timeout.
+type ProtocOptions struct {
+	Executor Executor      // Runs protoc. If nil, a LocalExecutor is used.
+	Logger   Logger        // If nil, the default Logger is used.
+	Timeout  time.Duration // If non-zero, how long a protoc call may take.
+}
+
+// executor returns the Executor to run protoc with.
+func (o ProtocOptions) executor() Executor {
+	if o.Executor == nil {
+		return LocalExecutor{}
+	}
+	return o.Executor
+}
+
+// logger returns the Logger to log to.
+func (o ProtocOptions) logger() Logger {
+	if o.Logger == nil {
+		return defaultLogger
+	}
+	return o.Logger
+}
+
+// protocOptions returns the options for running protoc on behalf of
+// the Wrapper.
+func (w *Wrapper) protocOptions() ProtocOptions {
+	return ProtocOptions{Executor: w.Executor, Logger: w.Logger, Timeout: w.ProtocTimeout}
+}
+
+// Executor runs commands on behalf of a Wrapper. Implementations can
+// run protoc somewhere other than locally, such as in a sandbox or a
+// container, or record or fake its invocations. Relative paths in the
+// arguments are relative to the working directory, and the files
+// protoc writes must end up in the local filesystem.
+type Executor interface {
+	// Run runs the command with the given arguments, and returns its
+	// combined stdout and stderr. If the context is done, it should
+	// stop the command and return an error.
+	Run(ctx context.Context, command string, args []string) ([]byte, error)
+}
+
+// ToolsIdentifier can be implemented by an Executor to identify the
+// protoc and plugin binaries it runs, for --stamp_dir stamps. The
+// hash should change whenever the tools do.
+type ToolsIdentifier interface {
+	ToolsHash(protocCommand string, protocFlags []string) (string, error)
+}
+
+// LocalExecutor is the default Executor: it runs commands locally.
+// Where process groups are supported, cancelling a command kills its
+// children, such as protoc's plugins, too.
+type LocalExecutor struct{}
+
+// Run implements the Executor interface.
+func (LocalExecutor) Run(ctx context.Context, command string, args []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, command, args...)
+	if ctx.Done() != nil {
+		killProcessGroup(cmd)
+	}
+	return cmd.CombinedOutput()
+}
+
+// ToolsHash implements the ToolsIdentifier interface, by hashing the
+// local binaries.
+func (LocalExecutor) ToolsHash(protocCommand string, protocFlags []string) (string, error) {
+	return ToolsHash(protocCommand, protocFlags)
+}
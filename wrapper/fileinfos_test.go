@@ -0,0 +1,115 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/square/goprotowrap/wrapper"
+	"github.com/square/goprotowrap/wrapper/wrappertest"
+)
+
+func TestGetFileInfosBatches(t *testing.T) {
+	// Enough protos for two batches, all importing one common file.
+	tree := wrappertest.NewTree(t).Add("common/common.proto", "ex.com/common")
+	protos := []string{}
+	for i := 0; i < 600; i++ {
+		name := fmt.Sprintf("p/p%d.proto", i)
+		tree.Add(name, "ex.com/p", "common/common.proto")
+		protos = append(protos, tree.Path(name))
+	}
+	protoc := wrappertest.NewProtoc(tree)
+
+	infos, err := wrapper.GetFileInfosContext(context.Background(), wrapper.ProtocOptions{Executor: protoc}, []string{tree.Dir}, protos, "protoc", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	for _, inv := range protoc.Invocations() {
+		if inv.DescriptorSetOut != "" {
+			calls++
+		}
+	}
+	if calls != 2 {
+		t.Errorf("want 2 protoc calls; got %d", calls)
+	}
+	if len(infos) != len(protos)+1 {
+		t.Errorf("want %d FileInfos; got %d", len(protos)+1, len(infos))
+	}
+	if info := infos["common/common.proto"]; info == nil || info.GoPackage != "ex.com/common" {
+		t.Errorf("want common/common.proto with go_package ex.com/common; got %+v", info)
+	}
+	for i := 0; i < len(protos); i++ {
+		name := fmt.Sprintf("p/p%d.proto", i)
+		info := infos[name]
+		if info == nil {
+			t.Errorf("missing FileInfo for %s", name)
+			continue
+		}
+		if want := []string{"common/common.proto"}; info.GoPackage != "ex.com/p" || !reflect.DeepEqual(info.Deps, want) {
+			t.Errorf("%s: want go_package ex.com/p and deps %v; got %+v", name, want, info)
+		}
+	}
+}
+
+func TestInitCache(t *testing.T) {
+	tree := wrappertest.NewTree(t).
+		Add("a/a.proto", "ex.com/a", "b/b.proto").
+		Add("b/b.proto", "ex.com/b").
+		Add("c/c.proto", "ex.com/c")
+	protoc := wrappertest.NewProtoc(tree)
+	cacheDir := t.TempDir()
+	// parsed runs Init with a new Wrapper, and returns the files
+	// protoc was asked to describe.
+	parsed := func() []string {
+		t.Helper()
+		protoc.Reset()
+		w := &wrapper.Wrapper{
+			ProtocCommand: "protoc",
+			Executor:      protoc,
+			Parallelism:   1,
+			CacheDir:      cacheDir,
+			ProtocFlags:   []string{"-I" + tree.Dir, "--go_out=gen"},
+			ImportDirs:    []string{tree.Dir},
+			ProtoFiles:    tree.Paths(),
+		}
+		if err := w.Init(); err != nil {
+			t.Fatal(err)
+		}
+		files := []string{}
+		for _, inv := range protoc.Invocations() {
+			if inv.DescriptorSetOut != "" {
+				files = append(files, inv.Files...)
+			}
+		}
+		sort.Strings(files)
+		return files
+	}
+
+	if got, want := parsed(), []string{"a/a.proto", "b/b.proto", "c/c.proto"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first run: want %v parsed; got %v", want, got)
+	}
+	if got := parsed(); len(got) != 0 {
+		t.Errorf("unchanged: want nothing parsed; got %v", got)
+	}
+	tree.Add("c/c.proto", "ex.com/c2")
+	if got, want := parsed(), []string{"c/c.proto"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after editing c/c.proto: want %v parsed; got %v", want, got)
+	}
+}
@@ -19,13 +19,16 @@
 package wrapper
 
 import (
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
 
 // ProtosBelow returns a slice containing the filenames of all .proto
-// files found in or below the given directories.
+// files found in or below the given directories. Unlike FindProtos, it
+// doesn't honor .protowrapignore files.
 func ProtosBelow(dirs []string) ([]string, error) {
 	protos := []string{}
 	for _, dir := range dirs {
@@ -79,3 +82,183 @@ func Disjoint(existing, additional []string) []string {
 	}
 	return result
 }
+
+// IgnoreFilename is the name of the files, found in or below import
+// directories, which list .proto files and directories to ignore,
+// with gitignore semantics.
+const IgnoreFilename = ".protowrapignore"
+
+// FindProtos returns a slice containing the filenames of all .proto
+// files found in or below the given import directories, skipping
+// those excluded by .protowrapignore files, those whose
+// import-path-relative names match any of the exclude globs, and (if
+// there are any include globs) those that don't match one of the
+// include globs. Excluded directories are not walked. See MatchGlob
+// for the glob syntax.
+func FindProtos(dirs []string, include, exclude []string) ([]string, error) {
+	protos := []string{}
+	for _, dir := range dirs {
+		ignores := map[string][]ignoreRule{}
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if info.IsDir() {
+				if rel != "." && (ignored(ignores, rel, true) || matchAnyGlob(exclude, rel)) {
+					return filepath.SkipDir
+				}
+				rules, err := readIgnoreFile(filepath.Join(path, IgnoreFilename), rel)
+				if err != nil {
+					return err
+				}
+				ignores[rel] = rules
+				return nil
+			}
+			if !strings.HasSuffix(info.Name(), ".proto") || ignored(ignores, rel, false) || matchAnyGlob(exclude, rel) {
+				return nil
+			}
+			if len(include) > 0 && !matchAnyGlob(include, rel) {
+				return nil
+			}
+			protos = append(protos, path)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return protos, nil
+}
+
+// ignoreRule is a single pattern from a .protowrapignore file.
+type ignoreRule struct {
+	pattern  []string // The split glob pattern
+	base     string   // The directory containing the ignore file, relative to the import directory
+	negate   bool     // The pattern started with "!": re-include matches
+	dirOnly  bool     // The pattern ended with "/": only match directories
+	anchored bool     // The pattern contained a "/": match relative to base only
+}
+
+// readIgnoreFile reads the rules from an ignore file, if it exists,
+// in directory base (relative to the import directory).
+func readIgnoreFile(filename string, base string) ([]ignoreRule, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rules := []ignoreRule{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rule := ignoreRule{base: base}
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		} else if line[0] == '\\' {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.pattern = strings.Split(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ignored returns true if the path (relative to the import directory)
+// is ignored by the rules in ignore files in its ancestor
+// directories. As with gitignore, the last matching rule wins, and
+// rules in deeper directories come later.
+func ignored(ignores map[string][]ignoreRule, rel string, isDir bool) bool {
+	dirs := []string{"."}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		dirs = append(dirs, strings.Join(parts[:i], "/"))
+	}
+
+	result := false
+	for _, dir := range dirs {
+		for _, rule := range ignores[dir] {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			name := rel
+			if rule.base != "." {
+				name = strings.TrimPrefix(rel, rule.base+"/")
+			}
+			pattern := rule.pattern
+			if !rule.anchored {
+				pattern = append([]string{"**"}, pattern...)
+			}
+			if matchElements(pattern, strings.Split(name, "/")) {
+				result = !rule.negate
+			}
+		}
+	}
+	return result
+}
+
+// MatchGlob returns true if the slash-separated name matches the
+// glob pattern. Within a path element, the syntax is that of
+// path.Match; a "**" element matches zero or more whole elements. A
+// pattern with no slash matches if any element of the name matches
+// it, so "testdata" matches "foo/testdata/bar.proto" and "*_test.proto"
+// matches "foo/bar_test.proto".
+func MatchGlob(pattern, name string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		return matchElements([]string{"**", pattern, "**"}, strings.Split(name, "/"))
+	}
+	return matchElements(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchElements matches a split glob pattern against a split name.
+func matchElements(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchElements(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchElements(pattern[1:], name[1:])
+}
+
+// matchAnyGlob returns true if the name matches any of the patterns.
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if MatchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
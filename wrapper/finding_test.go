@@ -0,0 +1,112 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"testdata", "foo/testdata/bar.proto", true},
+		{"testdata", "foo/testdatas/bar.proto", false},
+		{"*_test.proto", "foo/bar_test.proto", true},
+		{"foo/*.proto", "foo/bar.proto", true},
+		{"foo/*.proto", "foo/baz/bar.proto", false},
+		{"foo/**/*.proto", "foo/bar.proto", true},
+		{"foo/**/*.proto", "foo/baz/qux/bar.proto", true},
+		{"/foo/**", "foo/baz/bar.proto", true},
+		{"foo/**", "bar/foo/baz.proto", false},
+		{"third_party/**", "third_party/x.proto", true},
+	}
+	for _, tt := range tests {
+		if got := MatchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("MatchGlob(%q, %q): want %v; got %v", tt.pattern, tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestFindProtos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "findtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"a/a.proto":                    "",
+		"a/testdata/broken.proto":      "",
+		"a/b/b.proto":                  "",
+		"a/b/b_test.proto":             "",
+		"a/b/keep_test.proto":          "",
+		"a/b/" + IgnoreFilename:        "!keep_test.proto\n",
+		"vendor/v.proto":               "",
+		"c/c.proto":                    "",
+		"c/gen/gen.proto":              "",
+		"c/d/gen/gen.proto":            "",
+		IgnoreFilename:                 "# comment\n/vendor/\n*_test.proto\nc/gen\n",
+		"a/not_a_proto.txt":            "",
+		"other/generated/other.proto":  "",
+		"other/generated/other2.proto": "",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	protos, err := FindProtos([]string{dir}, nil, []string{"testdata", "other/**/other2.proto"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a/a.proto", "a/b/b.proto", "a/b/keep_test.proto", "c/c.proto", "c/d/gen/gen.proto", "other/generated/other.proto"}
+	for i := range want {
+		want[i] = filepath.Join(dir, want[i])
+	}
+	if !sliceStringEqual(protos, want) {
+		t.Errorf("want %v; got %v", want, protos)
+	}
+
+	protos, err = FindProtos([]string{dir}, []string{"c/**"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{filepath.Join(dir, "c/c.proto"), filepath.Join(dir, "c/d/gen/gen.proto")}
+	if !sliceStringEqual(protos, want) {
+		t.Errorf("want %v; got %v", want, protos)
+	}
+
+	// ProtosBelow ignores nothing.
+	protos, err = ProtosBelow([]string{filepath.Join(dir, "a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"a/a.proto", "a/b/b.proto", "a/b/b_test.proto", "a/b/keep_test.proto", "a/testdata/broken.proto"}
+	for i := range want {
+		want[i] = filepath.Join(dir, want[i])
+	}
+	if !sliceStringEqual(protos, want) {
+		t.Errorf("ProtosBelow: want %v; got %v", want, protos)
+	}
+}
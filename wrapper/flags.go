@@ -23,6 +23,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Flags that take no values. See
@@ -38,17 +39,34 @@ var noValueFlags = map[string]bool{
 }
 
 // Flag values is a simple map of parsed flag values. A map of string
-// to string, with convenience getters.
+// to string, with convenience getters. If a flag is given more than
+// once, it has the last value given.
 type FlagValues map[string]string
 
+// RepeatedFlagValues is a map of parsed flag values for flags that
+// can be given more than once: a map of flag name to all the values
+// given, in order.
+type RepeatedFlagValues map[string][]string
+
 // ParseArgs parses protoc-style commandline arguments, splitting them
 // into custom flags, protoc flags and input files, and capturing a
 // list of import directories. Custom flag names are passed without
-// dashes, and are expected to be specified with two dashes. If
-// customFlagNames[name] is true, the custom flag expects a value;
-// otherwise it can have no value, and will get a value of "".
+// dashes, and are expected to be specified with two dashes, although
+// single-letter ones that take no value may also be specified with
+// one. If customFlagNames[name] is true, the custom flag expects a
+// value; otherwise it can have no value, and will get a value of "".
 func ParseArgs(args []string, custom map[string]bool) (customFlags FlagValues, protocFlags, protos, importDirs []string, err error) {
-	customFlags = make(FlagValues)
+	repeated, protocFlags, protos, importDirs, err := ParseRepeatedArgs(args, custom)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return repeated.Last(), protocFlags, protos, importDirs, nil
+}
+
+// ParseRepeatedArgs is like ParseArgs, but returns every value given
+// for each custom flag.
+func ParseRepeatedArgs(args []string, custom map[string]bool) (customFlags RepeatedFlagValues, protocFlags, protos, importDirs []string, err error) {
+	customFlags = make(RepeatedFlagValues)
 
 	// Support protoc-style argument files starting with '@'
 	fullArgs := make([]string, 0, len(args))
@@ -74,7 +92,7 @@ func ParseArgs(args []string, custom map[string]bool) (customFlags FlagValues, p
 		}
 
 		if nextIsCustomFlag {
-			customFlags[customName] = arg
+			customFlags.add(customName, arg)
 			nextIsCustomFlag = false
 			continue
 		}
@@ -107,10 +125,10 @@ func ParseArgs(args []string, custom map[string]bool) (customFlags FlagValues, p
 						nextIsCustomFlag = true
 						customName = name
 					} else {
-						customFlags[name] = ""
+						customFlags.add(name, "")
 					}
 				} else {
-					customFlags[name] = parts[1]
+					customFlags.add(name, parts[1])
 				}
 				continue
 			}
@@ -121,6 +139,11 @@ func ParseArgs(args []string, custom map[string]bool) (customFlags FlagValues, p
 			continue
 		}
 
+		if needsValue, isCustom := custom[arg[1:]]; isCustom && !needsValue && len(arg) == 2 {
+			customFlags.add(arg[1:], "")
+			continue
+		}
+
 		protocFlags = append(protocFlags, arg)
 		// One dash. Expect single-char flag with value concatenated, or second arg for value.
 		// Capture import directory (-I) values separately.
@@ -152,6 +175,19 @@ func (fv FlagValues) Int(name string, defaultValue int) (int, error) {
 	return i, nil
 }
 
+// Duration returns the time.Duration version of a flag, if set.
+func (fv FlagValues) Duration(name string, defaultValue time.Duration) (time.Duration, error) {
+	value, found := fv[name]
+	if !found {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("flag %q: cannot parse duration from %q", name, value)
+	}
+	return d, nil
+}
+
 // Bool returns the boolean version of a flag, if set.
 func (fv FlagValues) Bool(name string, defaultValue bool) (bool, error) {
 	value, found := fv[name]
@@ -182,6 +218,60 @@ func (fv FlagValues) String(name string, defaultValue string) string {
 	return value
 }
 
+// add records a value for a flag.
+func (rv RepeatedFlagValues) add(name, value string) {
+	rv[name] = append(rv[name], value)
+}
+
+// Last returns the FlagValues for the flags: the last value given for
+// each.
+func (rv RepeatedFlagValues) Last() FlagValues {
+	fv := FlagValues{}
+	for name, values := range rv {
+		if len(values) > 0 {
+			fv[name] = values[len(values)-1]
+		}
+	}
+	return fv
+}
+
+// Strings returns all the values given for a flag, in order, or nil
+// if it wasn't set.
+func (rv RepeatedFlagValues) Strings(name string) []string {
+	if len(rv[name]) == 0 {
+		return nil
+	}
+	return append([]string(nil), rv[name]...)
+}
+
+// FileList returns the filenames given in all the values of a flag,
+// in order. Each value is either a comma-separated list of filenames,
+// or "@file", naming a file that lists one filename per line. It
+// returns nil if the flag wasn't set.
+func (rv RepeatedFlagValues) FileList(name string) ([]string, error) {
+	if len(rv[name]) == 0 {
+		return nil, nil
+	}
+	result := []string{}
+	for _, value := range rv[name] {
+		var names []string
+		if strings.HasPrefix(value, "@") {
+			var err error
+			if names, err = expandArgumentFile(value[1:]); err != nil {
+				return nil, fmt.Errorf("flag %q: %v", name, err)
+			}
+		} else {
+			names = strings.Split(value, ",")
+		}
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				result = append(result, name)
+			}
+		}
+	}
+	return result, nil
+}
+
 // expandArgumentFile reads additional command line argument from a file.
 func expandArgumentFile(filename string) ([]string, error) {
 	f, err := os.Open(filename)
@@ -15,6 +15,8 @@
 package wrapper
 
 import (
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
 )
@@ -47,6 +49,15 @@ func TestParseArgs(t *testing.T) {
 			[]string{".", "includes"},
 			false,
 		},
+		"single-dash custom args": {
+			"-I. -v foo1.proto -q",
+			map[string]bool{"v": false, "q": false},
+			map[string]string{"v": "", "q": ""},
+			[]string{"-I."},
+			[]string{"foo1.proto"},
+			[]string{"."},
+			false,
+		},
 		"real custom args": {
 			"-I. foo1.proto --parallelism=3 --only_specified_files=false --print_structure --protoc_command protoc",
 			realCustom,
@@ -105,3 +116,58 @@ func TestParseArgs(t *testing.T) {
 		}
 	}
 }
+
+func TestRepeatedFlags(t *testing.T) {
+	custom := map[string]bool{"exclude": true, "parallelism": true}
+	args := strings.Split("-I. --exclude=a --parallelism 2 --exclude b --parallelism=3 foo.proto", " ")
+	repeated, _, _, _, err := ParseRepeatedArgs(args, custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := repeated.Strings("exclude"); !sliceStringEqual(got, []string{"a", "b"}) {
+		t.Errorf("want exclude=[a b]; got %v", got)
+	}
+	if got := repeated["parallelism"]; !sliceStringEqual(got, []string{"2", "3"}) {
+		t.Errorf("want repeated[parallelism]=[2 3]; got %v", got)
+	}
+	if got := repeated.Strings("missing"); got != nil {
+		t.Errorf("want missing=nil; got %v", got)
+	}
+
+	flags, _, _, _, err := ParseArgs(args, custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := flags.Int("parallelism", 0); err != nil || got != 3 {
+		t.Errorf("want parallelism=3; got %v, %v", got, err)
+	}
+	if got := flags.String("exclude", ""); got != "b" {
+		t.Errorf("want exclude=b; got %q", got)
+	}
+}
+
+func TestFileList(t *testing.T) {
+	f, err := ioutil.TempFile("", "changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("c.proto\n\n d.proto \n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	flags := RepeatedFlagValues{}
+	flags.add("changed_files", "a.proto,b.proto")
+	flags.add("changed_files", "@"+f.Name())
+	got, err := flags.FileList("changed_files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.proto", "b.proto", "c.proto", "d.proto"}; !sliceStringEqual(got, want) {
+		t.Errorf("want %v; got %v", want, got)
+	}
+	if got, err := flags.FileList("missing"); got != nil || err != nil {
+		t.Errorf("want nil, nil; got %v, %v", got, err)
+	}
+}
@@ -18,16 +18,80 @@ package wrapper
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 )
 
-// Generate does the actual generation of protos.
-func Generate(pkg *PackageInfo, importDirs []string, protocCommand string, protocFlags []string, printOnly bool) (err error) {
+// PackageError is an error generating a single package.
+type PackageError struct {
+	Package string // The ComputedPackage of the package.
+	Err     error
+}
+
+// Error implements the error interface.
+func (e *PackageError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *PackageError) Unwrap() error {
+	return e.Err
+}
+
+// GenerateError is the error returned by Wrapper.Generate when
+// KeepGoing is set and packages fail to generate.
+type GenerateError struct {
+	Errors []*PackageError // Sorted by package.
+}
+
+// Error implements the error interface.
+func (e *GenerateError) Error() string {
+	result := []string{fmt.Sprintf("%d package(s) failed to generate:\n", len(e.Errors))}
+	for _, err := range e.Errors {
+		msg := err.Error()
+		if !strings.HasSuffix(msg, "\n") {
+			msg += "\n"
+		}
+		result = append(result, msg)
+	}
+	return strings.Join(result, "")
+}
+
+// CanceledError is the error returned by Wrapper.GenerateContext when
+// the context is done before every package is generated.
+type CanceledError struct {
+	Err        error    // The context's error.
+	Unfinished []string // The packages not (or not completely) generated, sorted.
+}
+
+// Error implements the error interface.
+func (e *CanceledError) Error() string {
+	result := []string{fmt.Sprintf("generation stopped (%v); unfinished packages:", e.Err)}
+	for _, pkg := range e.Unfinished {
+		result = append(result, " "+pkg)
+	}
+	return strings.Join(result, "\n") + "\n"
+}
+
+// Unwrap returns the context's error.
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// Generate does the actual generation of protos. If protoc fails, the
+// error is a *ProtocError.
+func Generate(pkg *PackageInfo, importDirs []string, protocCommand string, protocFlags []string, printOnly bool) error {
+	return GenerateContext(context.Background(), ProtocOptions{}, pkg, importDirs, protocCommand, protocFlags, printOnly)
+}
+
+// GenerateContext is like Generate, but runs protoc as the options
+// say, and kills it if the context is done.
+func GenerateContext(ctx context.Context, opts ProtocOptions, pkg *PackageInfo, importDirs []string, protocCommand string, protocFlags []string, printOnly bool) error {
 	args := protocFlags[0:len(protocFlags):len(protocFlags)]
 
 	files := make([]string, 0, len(pkg.Files))
@@ -38,14 +102,36 @@ func Generate(pkg *PackageInfo, importDirs []string, protocCommand string, proto
 	args = append(args, files...)
 
 	if printOnly {
+		// The command line is the output asked for, so it is printed
+		// to stdout rather than logged.
 		fmt.Printf("%s %s\n", protocCommand, strings.Join(args, " "))
 		return nil
 	}
-	cmd := exec.Command(protocCommand, args...)
-	out, err := cmd.CombinedOutput()
+	return runProtoc(ctx, opts, protocCommand, args, pkg.ComputedPackage)
+}
+
+// runProtoc runs protoc with the given arguments, as the options say,
+// and logs the command line and how long it took at debug level. If it
+// fails, the error is a *ProtocError, whose diagnostics are attributed
+// to the given package, if any. If the context is done, or protoc runs
+// for longer than the options' timeout, protoc is stopped.
+func runProtoc(ctx context.Context, opts ProtocOptions, protocCommand string, args []string, pkg string) error {
+	parent := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	out, err := opts.executor().Run(ctx, protocCommand, args)
+	opts.logger().Debug("Ran protoc", "command", protocCommand+" "+strings.Join(args, " "), "duration", time.Since(start))
 	if err != nil {
-		cmdline := fmt.Sprintf("%s %s\n", protocCommand, strings.Join(args, " "))
-		return fmt.Errorf("error running %v\n%v\nOutput:\n======\n%s======\n", cmdline, err, out)
+		if parent.Err() != nil {
+			err = parent.Err()
+		} else if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %v", opts.Timeout)
+		}
+		return newProtocError(protocCommand, args, err, out, pkg)
 	}
 	return nil
 }
@@ -0,0 +1,184 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGenerateKeepGoing(t *testing.T) {
+	for _, dependencyOrder := range []bool{false, true} {
+		w := testWrapper(
+			&FileInfo{Name: "a/a.proto", GoPackage: "ex.com/a", Deps: []string{"b/b.proto"}},
+			&FileInfo{Name: "b/b.proto", GoPackage: "ex.com/b"},
+			&FileInfo{Name: "c/c.proto", GoPackage: "ex.com/c"},
+		)
+		w.Executor = &recordingExecutor{err: errors.New("exit status 1")}
+		w.Parallelism = 2
+		w.KeepGoing = true
+		w.DependencyOrder = dependencyOrder
+
+		err := w.Generate()
+		genErr, ok := err.(*GenerateError)
+		if !ok {
+			t.Fatalf("dependencyOrder=%v: want *GenerateError; got %v", dependencyOrder, err)
+		}
+		got := []string{}
+		for _, e := range genErr.Errors {
+			got = append(got, e.Package)
+		}
+		if want := []string{"ex.com/a;a", "ex.com/b;b", "ex.com/c;c"}; !sliceStringEqual(got, want) {
+			t.Errorf("dependencyOrder=%v: want failed packages %v; got %v", dependencyOrder, want, got)
+		}
+	}
+}
+
+func TestGenerateContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generatetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hang := filepath.Join(dir, "protoc")
+	if err := ioutil.WriteFile(hang, []byte("#!/bin/sh\nsleep 10\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	newWrapper := func() *Wrapper {
+		w := testWrapper(
+			&FileInfo{Name: "a/a.proto", GoPackage: "ex.com/a"},
+			&FileInfo{Name: "b/b.proto", GoPackage: "ex.com/b"},
+		)
+		w.ProtocCommand = hang
+		w.Parallelism = 1
+		return w
+	}
+
+	w := newWrapper()
+	w.ProtocTimeout = 50 * time.Millisecond
+	start := time.Now()
+	err = w.Generate()
+	var protocErr *ProtocError
+	if !errors.As(err, &protocErr) || !strings.Contains(protocErr.Err.Error(), "timed out") {
+		t.Errorf("want timeout error; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("want protoc killed after timeout; took %v", elapsed)
+	}
+
+	w = newWrapper()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = w.GenerateContext(ctx)
+	canceled, ok := err.(*CanceledError)
+	if !ok {
+		t.Fatalf("want *CanceledError; got %v", err)
+	}
+	if want := []string{"ex.com/a;a", "ex.com/b;b"}; !sliceStringEqual(canceled.Unfinished, want) {
+		t.Errorf("want unfinished packages %v; got %v", want, canceled.Unfinished)
+	}
+}
+
+// recordingExecutor is an Executor that records the commands it is
+// asked to run, instead of running them, and returns err.
+type recordingExecutor struct {
+	mu       sync.Mutex
+	commands []string
+	err      error
+}
+
+func (e *recordingExecutor) Run(ctx context.Context, command string, args []string) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.commands = append(e.commands, command+" "+strings.Join(args, " "))
+	return nil, e.err
+}
+
+func TestGenerateExecutor(t *testing.T) {
+	w := testWrapper(
+		&FileInfo{Name: "a/a.proto", GoPackage: "ex.com/a", Deps: []string{"b/b.proto"}},
+		&FileInfo{Name: "b/b.proto", GoPackage: "ex.com/b"},
+	)
+	executor := &recordingExecutor{}
+	w.Executor = executor
+	w.ProtocCommand = "sandboxed-protoc"
+	w.ProtocFlags = []string{"--go_out=gen"}
+	w.Parallelism = 1
+	w.DependencyOrder = true
+
+	if err := w.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"sandboxed-protoc --go_out=gen b/b.proto",
+		"sandboxed-protoc --go_out=gen a/a.proto",
+	}
+	if !sliceStringEqual(executor.commands, want) {
+		t.Errorf("want commands %q; got %q", want, executor.commands)
+	}
+}
+
+func TestDispatchInDependencyOrder(t *testing.T) {
+	// a imports b; d and e import each other.
+	w := testWrapper(
+		&FileInfo{Name: "a/a.proto", GoPackage: "ex.com/a", Deps: []string{"b/b.proto"}},
+		&FileInfo{Name: "b/b.proto", GoPackage: "ex.com/b"},
+		&FileInfo{Name: "c/c.proto", GoPackage: "ex.com/c"},
+		&FileInfo{Name: "d/d.proto", GoPackage: "ex.com/d", Deps: []string{"e/e.proto"}},
+		&FileInfo{Name: "e/e.proto", GoPackage: "ex.com/e", Deps: []string{"d/d.proto"}},
+	)
+	pkgChan := make(chan *PackageInfo)
+	doneChan := make(chan *PackageInfo)
+	errChan := make(chan error)
+	result := make(chan error, 1)
+	go func() {
+		result <- w.dispatchInDependencyOrder(context.Background(), w.packages, pkgChan, doneChan, errChan)
+	}()
+
+	// Everything but a is ready at once: the members of the d/e cycle
+	// don't wait for each other.
+	got := []string{}
+	for i := 0; i < 4; i++ {
+		got = append(got, (<-pkgChan).ComputedPackage)
+	}
+	if want := []string{"ex.com/b;b", "ex.com/c;c", "ex.com/d;d", "ex.com/e;e"}; !sliceStringEqual(got, want) {
+		t.Fatalf("want %v dispatched first; got %v", want, got)
+	}
+
+	for _, name := range []string{"ex.com/c;c", "ex.com/d;d", "ex.com/e;e"} {
+		doneChan <- w.packages[name]
+	}
+	select {
+	case pkg := <-pkgChan:
+		t.Fatalf("want nothing dispatched before ex.com/b;b is done; got %s", pkg.ComputedPackage)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	doneChan <- w.packages["ex.com/b;b"]
+	if pkg := <-pkgChan; pkg.ComputedPackage != "ex.com/a;a" {
+		t.Fatalf("want ex.com/a;a dispatched; got %s", pkg.ComputedPackage)
+	}
+	doneChan <- w.packages["ex.com/a;a"]
+	if err := <-result; err != nil {
+		t.Errorf("want no error; got %v", err)
+	}
+}
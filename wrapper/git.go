@@ -0,0 +1,88 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// git.go contains the code for finding changed files using git.
+
+package wrapper
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ChangedProtosSince returns the absolute paths of the .proto files
+// under the given import directories that differ between the git
+// revision ref and the working tree, according to git diff. Untracked
+// files are not included, and neither are removed files: nothing left
+// can import them, and removing a file doesn't change the code
+// generated for the rest of its package (--clean removes its own
+// generated code).
+func ChangedProtosSince(ref string, importDirs []string) ([]string, error) {
+	top, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	top = strings.TrimSpace(top)
+	out, err := git("diff", "--name-only", "--no-renames", "--diff-filter=d", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+
+	// git reports paths below the real top-level directory, so compare
+	// them against the import directories with symlinks resolved, but
+	// return them below the import directories as given.
+	absDirs := make([]string, 0, len(importDirs))
+	realDirs := make([]string, 0, len(importDirs))
+	for _, dir := range importDirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return nil, err
+		}
+		absDirs = append(absDirs, abs)
+		realDirs = append(realDirs, real)
+	}
+	result := []string{}
+	for _, name := range strings.Split(out, "\n") {
+		if !strings.HasSuffix(name, ".proto") {
+			continue
+		}
+		path := filepath.Join(top, filepath.FromSlash(name))
+		for i, dir := range realDirs {
+			if rel, err := filepath.Rel(dir, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				result = append(result, filepath.Join(absDirs[i], rel))
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// git runs git with the given arguments, returning its output.
+func git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v\n%s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
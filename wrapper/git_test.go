@@ -0,0 +1,97 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestChangedProtosSince(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "gittest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	write := func(name, contents string) {
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(args ...string) {
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	for _, name := range []string{"protos/a.proto", "protos/b.proto", "protos/c.proto", "protos/same.proto", "protos/README.md", "other/o.proto"} {
+		write(name, "")
+	}
+	run("init", "-q")
+	run("add", ".")
+	run("commit", "-q", "-m", "initial")
+
+	write("protos/a.proto", "changed") // Modified.
+	run("rm", "-q", "protos/b.proto")  // Deleted.
+	if err := os.Mkdir("protos/sub", 0777); err != nil {
+		t.Fatal(err)
+	}
+	run("mv", "protos/c.proto", "protos/sub/c2.proto") // Renamed.
+	write("protos/..foo/x.proto", "changed")           // Added, with a name starting "..".
+	write("protos/README.md", "changed")               // Not a .proto file.
+	write("other/o.proto", "changed")                  // Outside the import directories.
+	write("protos/untracked.proto", "changed")         // Untracked.
+	run("add", "protos/..foo/x.proto", "protos/sub")
+
+	got, err := ChangedProtosSince("HEAD", []string{"protos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, err := filepath.Abs("protos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(abs, "..foo", "x.proto"),
+		filepath.Join(abs, "a.proto"),
+		filepath.Join(abs, "sub", "c2.proto"),
+	}
+	if !sliceStringEqual(got, want) {
+		t.Errorf("want %v; got %v", want, got)
+	}
+
+	if _, err := ChangedProtosSince("no-such-ref", []string{"protos"}); err == nil {
+		t.Error("want error for unknown ref")
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// graph.go contains the code to export the Go package dependency
+// graph in formats suitable for visualization.
+
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Graph formats supported by WriteGraph.
+const (
+	GraphDOT     = "dot"     // Graphviz DOT.
+	GraphMermaid = "mermaid" // Mermaid flowchart.
+)
+
+// graphEdge is a package-level import, with the file-level imports
+// that cause it.
+type graphEdge struct {
+	from, to string
+	imports  []FileImport
+	inCycle  bool
+}
+
+// WriteGraph writes the graph of the packages reachable from the
+// packages being generated to the given io.Writer, in the given
+// format. Each edge is labeled with the file-level imports that cause
+// it, and packages and edges that form cycles are highlighted.
+func (w *Wrapper) WriteGraph(writer io.Writer, format string) error {
+	if !w.initCalled {
+		return errors.New("Init() must be called before WriteGraph()")
+	}
+
+	// All reachable packages are in some strongly-connected component.
+	component := map[string]int{}
+	names := []string{}
+	cycles := [][]string{}
+	for i, scc := range w.components() {
+		cycle := []string{}
+		for _, pkg := range scc {
+			component[pkg.ComputedPackage] = i
+			names = append(names, pkg.ComputedPackage)
+			cycle = append(cycle, pkg.ComputedPackage)
+		}
+		if len(scc) > 1 {
+			sort.Strings(cycle)
+			cycles = append(cycles, cycle)
+		}
+	}
+	sort.Strings(names)
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+
+	edges := []graphEdge{}
+	for _, name := range names {
+		pkg := w.allPackages[name]
+		imported := pkg.ImportedPackageComputedNames()
+		sort.Strings(imported)
+		for _, other := range imported {
+			edges = append(edges, graphEdge{
+				from:    name,
+				to:      other,
+				imports: w.fileImports(pkg, other),
+				inCycle: component[name] == component[other],
+			})
+		}
+	}
+
+	switch format {
+	case GraphDOT:
+		writeDOT(writer, names, cycles, edges)
+	case GraphMermaid:
+		writeMermaid(writer, names, cycles, edges)
+	default:
+		return fmt.Errorf("unknown graph format %q; want %q or %q", format, GraphDOT, GraphMermaid)
+	}
+	return nil
+}
+
+// writeDOT writes a graph in Graphviz DOT format. Each cycle is drawn
+// as a red cluster.
+func writeDOT(writer io.Writer, names []string, cycles [][]string, edges []graphEdge) {
+	escape := func(s string) string {
+		s = strings.Replace(s, `\`, `\\`, -1)
+		return strings.Replace(s, `"`, `\"`, -1)
+	}
+	quote := func(s string) string {
+		return `"` + escape(s) + `"`
+	}
+	inCycle := map[string]bool{}
+
+	fmt.Fprintln(writer, "digraph packages {")
+	fmt.Fprintln(writer, "  node [shape=box];")
+	for i, cycle := range cycles {
+		fmt.Fprintf(writer, "  subgraph cluster_cycle_%d {\n", i)
+		fmt.Fprintln(writer, "    label=\"cycle\";")
+		fmt.Fprintln(writer, "    color=red;")
+		for _, name := range cycle {
+			inCycle[name] = true
+			fmt.Fprintf(writer, "    %s [color=red];\n", quote(name))
+		}
+		fmt.Fprintln(writer, "  }")
+	}
+	for _, name := range names {
+		if !inCycle[name] {
+			fmt.Fprintf(writer, "  %s;\n", quote(name))
+		}
+	}
+	for _, edge := range edges {
+		label := []string{}
+		for _, imp := range edge.imports {
+			label = append(label, escape(imp.From+" imports "+imp.To))
+		}
+		// DOT's \n escape is a centered line break.
+		attrs := `label="` + strings.Join(label, `\n`) + `"`
+		if edge.inCycle {
+			attrs += ", color=red"
+		}
+		fmt.Fprintf(writer, "  %s -> %s [%s];\n", quote(edge.from), quote(edge.to), attrs)
+	}
+	fmt.Fprintln(writer, "}")
+}
+
+// writeMermaid writes a graph as a Mermaid flowchart. Packages in
+// cycles get the "cycle" class, and edges in cycles are drawn thick.
+func writeMermaid(writer io.Writer, names []string, cycles [][]string, edges []graphEdge) {
+	quote := func(s string) string {
+		return `"` + strings.Replace(s, `"`, "#quot;", -1) + `"`
+	}
+	ids := map[string]string{}
+	for i, name := range names {
+		ids[name] = fmt.Sprintf("p%d", i)
+	}
+
+	fmt.Fprintln(writer, "graph LR")
+	for _, name := range names {
+		fmt.Fprintf(writer, "  %s[%s]\n", ids[name], quote(name))
+	}
+	for _, edge := range edges {
+		label := []string{}
+		for _, imp := range edge.imports {
+			label = append(label, imp.From+" imports "+imp.To)
+		}
+		arrow := "-->"
+		if edge.inCycle {
+			arrow = "==>"
+		}
+		fmt.Fprintf(writer, "  %s %s|%s| %s\n", ids[edge.from], arrow, quote(strings.Join(label, "<br/>")), ids[edge.to])
+	}
+	if len(cycles) > 0 {
+		fmt.Fprintln(writer, "  classDef cycle stroke:#f00,stroke-width:2px")
+		for _, cycle := range cycles {
+			members := []string{}
+			for _, name := range cycle {
+				members = append(members, ids[name])
+			}
+			fmt.Fprintf(writer, "  class %s cycle\n", strings.Join(members, ","))
+		}
+	}
+}
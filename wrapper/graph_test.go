@@ -0,0 +1,73 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteGraph(t *testing.T) {
+	// a and b import each other; b imports c.
+	w := testWrapper(
+		&FileInfo{Name: "a/a.proto", GoPackage: "ex.com/a", Deps: []string{"b/b.proto"}},
+		&FileInfo{Name: "b/b.proto", GoPackage: "ex.com/b", Deps: []string{"a/a.proto", "c/c.proto"}},
+		&FileInfo{Name: "c/c.proto", GoPackage: "ex.com/c"},
+	)
+
+	tests := map[string]struct {
+		format string
+		want   string
+	}{
+		"dot": {GraphDOT, `digraph packages {
+  node [shape=box];
+  subgraph cluster_cycle_0 {
+    label="cycle";
+    color=red;
+    "ex.com/a;a" [color=red];
+    "ex.com/b;b" [color=red];
+  }
+  "ex.com/c;c";
+  "ex.com/a;a" -> "ex.com/b;b" [label="a/a.proto imports b/b.proto", color=red];
+  "ex.com/b;b" -> "ex.com/a;a" [label="b/b.proto imports a/a.proto", color=red];
+  "ex.com/b;b" -> "ex.com/c;c" [label="b/b.proto imports c/c.proto"];
+}
+`},
+		"mermaid": {GraphMermaid, `graph LR
+  p0["ex.com/a;a"]
+  p1["ex.com/b;b"]
+  p2["ex.com/c;c"]
+  p0 ==>|"a/a.proto imports b/b.proto"| p1
+  p1 ==>|"b/b.proto imports a/a.proto"| p0
+  p1 -->|"b/b.proto imports c/c.proto"| p2
+  classDef cycle stroke:#f00,stroke-width:2px
+  class p0,p1 cycle
+`},
+	}
+	for name, tt := range tests {
+		var buf bytes.Buffer
+		if err := w.WriteGraph(&buf, tt.format); err != nil {
+			t.Errorf("%q: %v", name, err)
+			continue
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%q: want:\n%s\ngot:\n%s", name, tt.want, got)
+		}
+	}
+
+	if err := w.WriteGraph(&bytes.Buffer{}, "svg"); err == nil {
+		t.Error("want error for unknown format")
+	}
+}
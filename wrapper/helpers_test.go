@@ -25,3 +25,24 @@ func mapStringStringEqual(a, b map[string]string) bool {
 func sliceStringEqual(a, b []string) bool {
 	return len(a) == len(b) && (len(a) == 0 || reflect.DeepEqual(a, b))
 }
+
+// testWrapper returns an initialized Wrapper for the given FileInfos,
+// as if all of them had been specified on the commandline. Only Name,
+// GoPackage and Deps need be set.
+func testWrapper(infos ...*FileInfo) *Wrapper {
+	w := &Wrapper{
+		ImportDirs: []string{"."},
+		infos:      map[string]*FileInfo{},
+	}
+	for _, info := range infos {
+		info.FullPath = info.Name
+		w.infos[info.Name] = info
+		w.ProtoFiles = append(w.ProtoFiles, info.Name)
+	}
+	w.allProtos = w.ProtoFiles
+	ComputeGoLocations(w.infos)
+	w.allPackages, _ = CollectPackages(w.infos, w.ProtoFiles, w.ImportDirs)
+	w.packages = w.allPackages
+	w.initCalled = true
+	return w
+}
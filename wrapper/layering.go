@@ -0,0 +1,200 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// layering.go contains the code for checking architecture layering
+// rules against the package graph.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// LayeringRule restricts which packages may import which. An import
+// of package T by package F violates the rule if F matches From (or
+// From is empty) and doesn't match ExceptFrom, and T matches Deny and
+// doesn't match Allow.
+//
+// Patterns are matched against the import path part of a package's
+// ComputedPackage. A pattern matches if it appears in the import path
+// as a sequence of whole path elements: "internal/ledger" matches
+// "example.com/internal/ledger" and "example.com/internal/ledger/v1",
+// but not "example.com/internal/ledgers". A leading "/" anchors the
+// pattern to the start of the import path.
+//
+// For example, "packages under internal/payments must not import
+// internal/ledger" is:
+//
+//	{"from": ["internal/payments"], "deny": ["internal/ledger"]}
+//
+// and "only api/public may be imported by protos outside
+// example.com" is:
+//
+//	{"except_from": ["/example.com"], "deny": ["/example.com"], "allow": ["/example.com/api/public"]}
+type LayeringRule struct {
+	Name       string   `json:"name,omitempty"`
+	From       []string `json:"from,omitempty"`
+	ExceptFrom []string `json:"except_from,omitempty"`
+	Deny       []string `json:"deny"`
+	Allow      []string `json:"allow,omitempty"`
+}
+
+// String returns the rule's name, or a description of it if it has
+// none.
+func (r LayeringRule) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	desc := fmt.Sprintf("deny %v", r.Deny)
+	if len(r.Allow) > 0 {
+		desc += fmt.Sprintf(" allow %v", r.Allow)
+	}
+	if len(r.From) > 0 {
+		desc += fmt.Sprintf(" from %v", r.From)
+	}
+	if len(r.ExceptFrom) > 0 {
+		desc += fmt.Sprintf(" except from %v", r.ExceptFrom)
+	}
+	return desc
+}
+
+// violatedBy returns true if an import of package to by package from
+// violates the rule.
+func (r LayeringRule) violatedBy(from, to string) bool {
+	from, to = importPath(from), importPath(to)
+	if len(r.From) > 0 && !matchesAny(from, r.From) {
+		return false
+	}
+	return !matchesAny(from, r.ExceptFrom) && matchesAny(to, r.Deny) && !matchesAny(to, r.Allow)
+}
+
+// LayeringRules is the serialized form of a file of layering rules.
+type LayeringRules struct {
+	Rules []LayeringRule `json:"rules"`
+}
+
+// ReadLayeringRules reads a JSON file of layering rules.
+func ReadLayeringRules(filename string) ([]LayeringRule, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	rules := LayeringRules{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for i, rule := range rules.Rules {
+		if len(rule.Deny) == 0 {
+			return nil, fmt.Errorf("%s: rule %d (%s) has no deny patterns", filename, i, rule)
+		}
+	}
+	return rules.Rules, nil
+}
+
+// LayeringViolation is a single package import that violates a
+// layering rule.
+type LayeringViolation struct {
+	Rule   string        `json:"rule"`
+	Import PackageImport `json:"import"`
+}
+
+// LayeringError is the error returned by CheckLayering when package
+// imports violate layering rules.
+type LayeringError struct {
+	Violations []LayeringViolation `json:"violations"`
+}
+
+// Error implements the error interface.
+func (e *LayeringError) Error() string {
+	result := []string{"layering violations found:"}
+	for _, v := range e.Violations {
+		result = append(result, fmt.Sprintf(" %s --> %s violates %q", v.Import.From, v.Import.To, v.Rule))
+		for _, imp := range v.Import.Imports {
+			result = append(result, fmt.Sprintf("  %s imports %s", imp.From, imp.To))
+		}
+	}
+	return strings.Join(result, "\n") + "\n"
+}
+
+// CheckLayering checks the imports of all packages reachable from the
+// packages being generated against the given rules. If there are any
+// violations, the error returned is a *LayeringError.
+func (w *Wrapper) CheckLayering(rules []LayeringRule) error {
+	if !w.initCalled {
+		return errors.New("Init() must be called before CheckLayering()")
+	}
+	names := []string{}
+	for _, scc := range w.components() {
+		for _, pkg := range scc {
+			names = append(names, pkg.ComputedPackage)
+		}
+	}
+	sort.Strings(names)
+
+	violations := []LayeringViolation{}
+	for _, name := range names {
+		pkg := w.allPackages[name]
+		imported := pkg.ImportedPackageComputedNames()
+		sort.Strings(imported)
+		for _, other := range imported {
+			for _, rule := range rules {
+				if rule.violatedBy(name, other) {
+					violations = append(violations, LayeringViolation{
+						Rule: rule.String(),
+						Import: PackageImport{
+							From:    name,
+							To:      other,
+							Imports: w.fileImports(pkg, other),
+						},
+					})
+				}
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return &LayeringError{Violations: violations}
+	}
+	return nil
+}
+
+// importPath returns the import path part of a ComputedPackage.
+func importPath(computedPackage string) string {
+	if semi := strings.Index(computedPackage, ";"); semi >= 0 {
+		return computedPackage[:semi]
+	}
+	return computedPackage
+}
+
+// matchesAny returns true if the import path matches any of the
+// patterns, as described on LayeringRule.
+func matchesAny(path string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "/") {
+			pattern = strings.Trim(pattern, "/")
+			if path == pattern || strings.HasPrefix(path, pattern+"/") {
+				return true
+			}
+			continue
+		}
+		if strings.Contains("/"+path+"/", "/"+strings.Trim(pattern, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
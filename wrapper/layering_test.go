@@ -0,0 +1,71 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchesAny(t *testing.T) {
+	tests := []struct {
+		path    string
+		pattern string
+		want    bool
+	}{
+		{"ex.com/internal/ledger", "internal/ledger", true},
+		{"ex.com/internal/ledger/v1", "internal/ledger/", true},
+		{"ex.com/internal/ledgers", "internal/ledger", false},
+		{"ex.com/internal/ledger", "/internal/ledger", false},
+		{"ex.com/api/public", "/ex.com", true},
+		{"ex.comx/api", "/ex.com", false},
+	}
+	for _, tt := range tests {
+		if got := matchesAny(tt.path, []string{tt.pattern}); got != tt.want {
+			t.Errorf("matchesAny(%q, %q): want %v; got %v", tt.path, tt.pattern, tt.want, got)
+		}
+	}
+}
+
+func TestCheckLayering(t *testing.T) {
+	w := testWrapper(
+		&FileInfo{Name: "payments/p.proto", GoPackage: "ex.com/internal/payments", Deps: []string{"ledger/l.proto", "public/p.proto"}},
+		&FileInfo{Name: "ledger/l.proto", GoPackage: "ex.com/internal/ledger"},
+		&FileInfo{Name: "public/p.proto", GoPackage: "ex.com/api/public"},
+		&FileInfo{Name: "private/p.proto", GoPackage: "ex.com/api/private"},
+		&FileInfo{Name: "other/o.proto", GoPackage: "other.com/o", Deps: []string{"public/p.proto", "private/p.proto"}},
+	)
+	rules := []LayeringRule{
+		{Name: "no ledger", From: []string{"internal/payments"}, Deny: []string{"internal/ledger"}},
+		{Name: "public only", ExceptFrom: []string{"/ex.com"}, Deny: []string{"/ex.com"}, Allow: []string{"/ex.com/api/public"}},
+	}
+	err, ok := w.CheckLayering(rules).(*LayeringError)
+	if !ok {
+		t.Fatal("want *LayeringError")
+	}
+	want := []LayeringViolation{
+		{Rule: "no ledger", Import: PackageImport{
+			From: "ex.com/internal/payments;payments", To: "ex.com/internal/ledger;ledger",
+			Imports: []FileImport{{From: "payments/p.proto", To: "ledger/l.proto"}},
+		}},
+		{Rule: "public only", Import: PackageImport{
+			From: "other.com/o;o", To: "ex.com/api/private;private",
+			Imports: []FileImport{{From: "other/o.proto", To: "private/p.proto"}},
+		}},
+	}
+	if !reflect.DeepEqual(err.Violations, want) {
+		t.Errorf("want violations %+v; got %+v", want, err.Violations)
+	}
+}
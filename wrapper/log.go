@@ -0,0 +1,97 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// log.go contains the Logger interface the Wrapper reports progress,
+// warnings and debugging information through, and the default,
+// plain-text implementation.
+
+package wrapper
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Logger is what a Wrapper logs through. Messages are followed by
+// alternating keys and values, as with log/slog; a *slog.Logger is a
+// Logger.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// defaultLogger is used when a Wrapper has no Logger, and by the
+// package-level functions.
+var defaultLogger = NewLogger(os.Stdout, os.Stderr, slog.LevelInfo)
+
+// NewLogger returns a Logger that writes messages at or above the
+// given level in plain text: the message followed by its values,
+// without keys, and with a colon before an "error" value. Debug and
+// Info messages go to stdout, and Warn and Error messages, prefixed
+// with "Warning: " and "Error: ", to stderr.
+func NewLogger(stdout, stderr io.Writer, level slog.Level) Logger {
+	return &textLogger{stdout: stdout, stderr: stderr, level: level}
+}
+
+// textLogger is the Logger returned by NewLogger.
+type textLogger struct {
+	mu     sync.Mutex
+	stdout io.Writer
+	stderr io.Writer
+	level  slog.Level
+}
+
+func (l *textLogger) Debug(msg string, args ...any) { l.log(slog.LevelDebug, msg, args) }
+func (l *textLogger) Info(msg string, args ...any)  { l.log(slog.LevelInfo, msg, args) }
+func (l *textLogger) Warn(msg string, args ...any)  { l.log(slog.LevelWarn, msg, args) }
+func (l *textLogger) Error(msg string, args ...any) { l.log(slog.LevelError, msg, args) }
+
+func (l *textLogger) log(level slog.Level, msg string, args []any) {
+	if level < l.level {
+		return
+	}
+	line := msg
+	for i := 1; i < len(args); i += 2 {
+		sep := " "
+		if args[i-1] == "error" {
+			sep = ": "
+		}
+		line += sep + strings.TrimRight(fmt.Sprint(args[i]), "\n")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case level >= slog.LevelError:
+		fmt.Fprintf(l.stderr, "Error: %s\n", line)
+	case level >= slog.LevelWarn:
+		fmt.Fprintf(l.stderr, "Warning: %s\n", line)
+	default:
+		fmt.Fprintln(l.stdout, line)
+	}
+}
+
+// logger returns the Wrapper's Logger, or the default one.
+func (w *Wrapper) logger() Logger {
+	if w.Logger == nil {
+		return defaultLogger
+	}
+	return w.Logger
+}
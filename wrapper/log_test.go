@@ -0,0 +1,49 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := map[string]struct {
+		level  slog.Level
+		stdout string
+		stderr string
+	}{
+		"debug": {slog.LevelDebug, "Ran protoc protoc x.proto 1s\nGenerating package ex.com/a;a\n", "Warning: file \"a.proto\" has no go_package and no package.\nError: Regenerating failed: boom\n"},
+		"info":  {slog.LevelInfo, "Generating package ex.com/a;a\n", "Warning: file \"a.proto\" has no go_package and no package.\nError: Regenerating failed: boom\n"},
+		"warn":  {slog.LevelWarn, "", "Warning: file \"a.proto\" has no go_package and no package.\nError: Regenerating failed: boom\n"},
+	}
+
+	for name, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		logger := NewLogger(&stdout, &stderr, tt.level)
+		logger.Debug("Ran protoc", "command", "protoc x.proto", "duration", "1s")
+		logger.Info("Generating package", "package", "ex.com/a;a")
+		logger.Warn(`file "a.proto" has no go_package and no package.`)
+		logger.Error("Regenerating failed", "error", errors.New("boom\n"))
+		if got := stdout.String(); got != tt.stdout {
+			t.Errorf("%q: want stdout %q; got %q", name, tt.stdout, got)
+		}
+		if got := stderr.String(); got != tt.stderr {
+			t.Errorf("%q: want stderr %q; got %q", name, tt.stderr, got)
+		}
+	}
+}
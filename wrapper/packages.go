@@ -19,13 +19,15 @@
 package wrapper
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 
 	"github.com/golang/protobuf/proto"
@@ -69,11 +71,6 @@ type PackageInfo struct {
 	ComputedPackage string
 	Files           []*FileInfo
 	Deps            []*FileInfo
-
-	// Internal use for cycle-checking and topological sorting.
-	index   int
-	lowlink int
-	onStack bool
 }
 
 // PackageDir returns the desired directory location for the given
@@ -108,15 +105,34 @@ func (p PackageInfo) ImportedPackageComputedNames() []string {
 	return result
 }
 
+// minBatchSize is the smallest number of protos worth handing to a
+// separate protoc process when collecting FileDescriptorSets. Every
+// batch re-parses its transitive imports, so tiny batches are a loss.
+const minBatchSize = 250
+
 // GetFileInfos gets the FileInfo struct for every proto passed in.
-func GetFileInfos(importPaths []string, protos []string, protocCommand string) (info map[string]*FileInfo, err error) {
+func GetFileInfos(importPaths []string, protos []string, protocCommand string) (map[string]*FileInfo, error) {
+	return GetFileInfosParallel(importPaths, protos, protocCommand, 1)
+}
+
+// GetFileInfosParallel is like GetFileInfos, but splits the protos
+// into batches, and runs up to parallelism calls to protoc at once.
+func GetFileInfosParallel(importPaths []string, protos []string, protocCommand string, parallelism int) (map[string]*FileInfo, error) {
+	return GetFileInfosContext(context.Background(), ProtocOptions{}, importPaths, protos, protocCommand, parallelism)
+}
+
+// GetFileInfosContext is like GetFileInfosParallel, but runs protoc as
+// the options say, and kills the protoc calls if the context is done.
+func GetFileInfosContext(ctx context.Context, opts ProtocOptions, importPaths []string, protos []string, protocCommand string, parallelism int) (info map[string]*FileInfo, err error) {
 	if len(importPaths) == 0 {
 		return nil, fmt.Errorf("GetFileInfos: empty importPaths")
 	}
 	if len(protos) == 0 {
 		return nil, fmt.Errorf("GetFileInfos: empty protos")
 	}
-	info = map[string]*FileInfo{}
+	if parallelism < 1 {
+		return nil, fmt.Errorf("GetFileInfos: parallelism cannot be < 1; got %d", parallelism)
+	}
 
 	var dir string
 	dir, err = ioutil.TempDir("", "filedescriptors")
@@ -129,7 +145,87 @@ func GetFileInfos(importPaths []string, protos []string, protocCommand string) (
 		}
 	}()
 
-	descriptorFilename := filepath.Join(dir, "all.pb")
+	batches := splitBatches(protos, parallelism, minBatchSize)
+	if parallelism > len(batches) {
+		parallelism = len(batches)
+	}
+
+	logger := opts.logger()
+	logger.Info("Collecting filedescriptors...")
+	start := time.Now()
+
+	sets := make([]*descriptor.FileDescriptorSet, len(batches))
+	batchChan := make(chan int)
+	errChan := make(chan error, parallelism)
+	var wg sync.WaitGroup
+	wg.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			for batch := range batchChan {
+				set, err := getFileDescriptorSet(ctx, opts, importPaths, batches[batch], protocCommand, dir, batch)
+				if err != nil {
+					errChan <- err
+					continue
+				}
+				sets[batch] = set
+			}
+			wg.Done()
+		}()
+	}
+
+OUTER:
+	for i := range batches {
+		select {
+		case batchChan <- i:
+		case err = <-errChan:
+			break OUTER
+		case <-ctx.Done():
+			err = ctx.Err()
+			break OUTER
+		}
+	}
+	close(batchChan)
+	wg.Wait()
+	if err == nil {
+		select {
+		case err = <-errChan:
+		default:
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Collected filedescriptors", "duration", time.Since(start))
+
+	// Merge the batches. Files pulled in by --include_imports can
+	// appear in more than one set; the first one wins.
+	info = map[string]*FileInfo{}
+	for _, set := range sets {
+		for _, fd := range set.File {
+			if _, seen := info[fd.GetName()]; seen {
+				continue
+			}
+			fi := &FileInfo{
+				Name:    fd.GetName(),
+				Package: fd.GetPackage(),
+			}
+			for _, dep := range fd.Dependency {
+				fi.Deps = append(fi.Deps, dep)
+			}
+			fi.GoPackage = fd.Options.GetGoPackage()
+			info[fi.Name] = fi
+		}
+	}
+
+	return info, nil
+}
+
+// getFileDescriptorSet runs protoc once over the given protos, and
+// returns the resulting FileDescriptorSet, including imports. The
+// batch number is used to name the files written to the scratch
+// directory dir.
+func getFileDescriptorSet(ctx context.Context, opts ProtocOptions, importPaths []string, protos []string, protocCommand string, dir string, batch int) (*descriptor.FileDescriptorSet, error) {
+	descriptorFilename := filepath.Join(dir, fmt.Sprintf("batch-%d.pb", batch))
 
 	args := []string{}
 	for _, importPath := range importPaths {
@@ -145,19 +241,15 @@ func GetFileInfos(importPaths []string, protos []string, protocCommand string) (
 		args = append(args, protos...)
 	} else {
 		// For a large number of protos, use a file containing the arguments
-		argfile := filepath.Join(dir, "protoc-args")
-		if err = ioutil.WriteFile(argfile, []byte(strings.Join(protos, "\n")), 0666); err != nil {
+		argfile := filepath.Join(dir, fmt.Sprintf("protoc-args-%d", batch))
+		if err := ioutil.WriteFile(argfile, []byte(strings.Join(protos, "\n")), 0666); err != nil {
 			return nil, err
 		}
 		args = append(args, "@"+argfile)
 	}
 
-	fmt.Println("Collecting filedescriptors...")
-	cmd := exec.Command(protocCommand, args...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		cmdline := fmt.Sprintf("%s %s\n", protocCommand, strings.Join(args, " "))
-		return nil, fmt.Errorf("error running %v\n%v\nOutput:\n======\n%s======\n", cmdline, err, out)
+	if err := runProtoc(ctx, opts, protocCommand, args, ""); err != nil {
+		return nil, err
 	}
 	descriptorSetBytes, err := ioutil.ReadFile(descriptorFilename)
 	if err != nil {
@@ -165,24 +257,34 @@ func GetFileInfos(importPaths []string, protos []string, protocCommand string) (
 	}
 
 	descriptorSet := &descriptor.FileDescriptorSet{}
-	err = proto.Unmarshal(descriptorSetBytes, descriptorSet)
-	if err != nil {
+	if err := proto.Unmarshal(descriptorSetBytes, descriptorSet); err != nil {
 		return nil, err
 	}
+	return descriptorSet, nil
+}
 
-	for _, fd := range descriptorSet.File {
-		fi := &FileInfo{
-			Name:    fd.GetName(),
-			Package: fd.GetPackage(),
-		}
-		for _, dep := range fd.Dependency {
-			fi.Deps = append(fi.Deps, dep)
+// splitBatches splits protos into at most n roughly equal batches,
+// none smaller than minSize (except when there are fewer than minSize
+// protos in total).
+func splitBatches(protos []string, n int, minSize int) [][]string {
+	if max := len(protos) / minSize; n > max {
+		n = max
+	}
+	if n < 1 {
+		n = 1
+	}
+	batches := make([][]string, 0, n)
+	size, extra := len(protos)/n, len(protos)%n
+	start := 0
+	for i := 0; i < n; i++ {
+		end := start + size
+		if i < extra {
+			end++
 		}
-		fi.GoPackage = fd.Options.GetGoPackage()
-		info[fi.Name] = fi
+		batches = append(batches, protos[start:end])
+		start = end
 	}
-
-	return info, nil
+	return batches
 }
 
 // ComputeGoLocations uses the package and go_package information to
@@ -191,6 +293,12 @@ func GetFileInfos(importPaths []string, protos []string, protocCommand string) (
 // redundant or not) as described in
 // github.com/golang/protobuf/issues/139
 func ComputeGoLocations(infos map[string]*FileInfo) {
+	computeGoLocations(infos, defaultLogger)
+}
+
+// computeGoLocations implements ComputeGoLocations, warning about
+// files with no package information through the given Logger.
+func computeGoLocations(infos map[string]*FileInfo, logger Logger) {
 	for _, info := range infos {
 		dir := filepath.Dir(info.Name)
 		pkg := info.GoPackage
@@ -210,7 +318,7 @@ func ComputeGoLocations(infos map[string]*FileInfo) {
 		}
 		if pkg == "" {
 			pkg = baseName(info.Name)
-			fmt.Fprintf(os.Stderr, "Warning: file %q has no go_package and no package.\n", info.Name)
+			logger.Warn(fmt.Sprintf("file %q has no go_package and no package.", info.Name))
 		}
 		info.ComputedPackage = dir + ";" + strings.Map(badToUnderscore, pkg)
 	}
@@ -262,24 +370,35 @@ func CollectPackages(infos map[string]*FileInfo, protos []string, importDirs []s
 // FileDescriptorName computes the import-dir-relative Name that the
 // FileDescriptor for a full filename will have.
 func FileDescriptorName(protoFile string, importDirs []string) string {
+	name, ok := fileDescriptorName(protoFile, importDirs)
+	if !ok {
+		panic(fmt.Sprintf("Unable to find import dir for %q", protoFile))
+	}
+	return name
+}
+
+// fileDescriptorName is like FileDescriptorName, but returns false
+// instead of panicking if the file isn't in any of the import
+// directories.
+func fileDescriptorName(protoFile string, importDirs []string) (string, bool) {
 	isAbs := path.IsAbs(protoFile)
 	for _, imp := range importDirs {
 		// Handle import dirs of "." - the FileDescriptorProtos don't have the "./" prefix.
 		if imp == "." && !isAbs {
 			if strings.HasPrefix(protoFile, "./") {
-				return protoFile[2:]
+				return protoFile[2:], true
 			}
-			return protoFile
+			return protoFile, true
 		}
 		if strings.HasPrefix(protoFile, imp) {
 			name := protoFile[len(imp):]
 			if strings.HasPrefix(name, "/") && imp != "/" {
-				return name[1:]
+				return name[1:], true
 			}
-			return name
+			return name, true
 		}
 	}
-	panic(fmt.Sprintf("Unable to find import dir for %q", protoFile))
+	return "", false
 }
 
 // AnnotateFullPaths annotates an existing set of FileInfos with their
@@ -0,0 +1,61 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSplitBatches(t *testing.T) {
+	protos := func(n int) []string {
+		result := make([]string, n)
+		for i := range result {
+			result[i] = fmt.Sprintf("foo%d.proto", i)
+		}
+		return result
+	}
+
+	tests := map[string]struct {
+		protos  int
+		n       int
+		minSize int
+		sizes   []int
+	}{
+		"single":         {1, 5, 10, []int{1}},
+		"below min size": {15, 5, 10, []int{15}},
+		"at min size":    {20, 5, 10, []int{10, 10}},
+		"even":           {100, 4, 10, []int{25, 25, 25, 25}},
+		"uneven":         {10, 3, 1, []int{4, 3, 3}},
+		"one worker":     {100, 1, 10, []int{100}},
+	}
+
+	for name, tt := range tests {
+		in := protos(tt.protos)
+		batches := splitBatches(in, tt.n, tt.minSize)
+		sizes := []int{}
+		var all []string
+		for _, batch := range batches {
+			sizes = append(sizes, len(batch))
+			all = append(all, batch...)
+		}
+		if fmt.Sprint(sizes) != fmt.Sprint(tt.sizes) {
+			t.Errorf("%q: want batch sizes %v; got %v", name, tt.sizes, sizes)
+		}
+		if !sliceStringEqual(all, in) {
+			t.Errorf("%q: batches do not cover input in order: %v", name, batches)
+		}
+	}
+}
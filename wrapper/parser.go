@@ -0,0 +1,349 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// parser.go contains a minimal in-process .proto parser, which
+// extracts just the information GetFileInfos gets from protoc: the
+// package, go_package option and imports of each file. It does not
+// validate the files; that is left to protoc when generating.
+
+package wrapper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Parsers that can be used to get FileInfos.
+const (
+	ParserProtoc = "protoc" // Call protoc to produce a FileDescriptorSet.
+	ParserGo     = "go"     // Parse .proto files in-process.
+)
+
+// wellKnownGoPackages maps the well-known .proto files that protoc
+// provides from its own include directory to their go_package. They
+// are only used if the files aren't found in the import directories.
+var wellKnownGoPackages = map[string]string{
+	"google/protobuf/any.proto":             "google.golang.org/protobuf/types/known/anypb",
+	"google/protobuf/api.proto":             "google.golang.org/protobuf/types/known/apipb",
+	"google/protobuf/compiler/plugin.proto": "google.golang.org/protobuf/types/pluginpb",
+	"google/protobuf/descriptor.proto":      "google.golang.org/protobuf/types/descriptorpb",
+	"google/protobuf/duration.proto":        "google.golang.org/protobuf/types/known/durationpb",
+	"google/protobuf/empty.proto":           "google.golang.org/protobuf/types/known/emptypb",
+	"google/protobuf/field_mask.proto":      "google.golang.org/protobuf/types/known/fieldmaskpb",
+	"google/protobuf/source_context.proto":  "google.golang.org/protobuf/types/known/sourcecontextpb",
+	"google/protobuf/struct.proto":          "google.golang.org/protobuf/types/known/structpb",
+	"google/protobuf/timestamp.proto":       "google.golang.org/protobuf/types/known/timestamppb",
+	"google/protobuf/type.proto":            "google.golang.org/protobuf/types/known/typepb",
+	"google/protobuf/wrappers.proto":        "google.golang.org/protobuf/types/known/wrapperspb",
+}
+
+// ParseFileInfos gets the FileInfo struct for every proto passed in,
+// and every file they transitively import, without calling protoc.
+func ParseFileInfos(importPaths []string, protos []string) (map[string]*FileInfo, error) {
+	if len(importPaths) == 0 {
+		return nil, fmt.Errorf("ParseFileInfos: empty importPaths")
+	}
+	if len(protos) == 0 {
+		return nil, fmt.Errorf("ParseFileInfos: empty protos")
+	}
+	infos := map[string]*FileInfo{}
+
+	var parse func(name, path, importedBy string) error
+	parse = func(name, path, importedBy string) error {
+		if _, seen := infos[name]; seen {
+			return nil
+		}
+		if path == "" {
+			path = findInImportDirs(name, importPaths)
+		}
+		if path == "" {
+			goPackage, ok := wellKnownGoPackages[name]
+			if !ok {
+				return fmt.Errorf("%s: import %q not found in import directories", importedBy, name)
+			}
+			infos[name] = &FileInfo{Name: name, Package: "google.protobuf", GoPackage: goPackage}
+			return nil
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		info, err := ParseFileInfo(name, string(contents))
+		if err != nil {
+			return fmt.Errorf("%s:%v", path, err)
+		}
+		infos[name] = info
+		for _, dep := range info.Deps {
+			if err := parse(dep, "", path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, proto := range protos {
+		if err := parse(FileDescriptorName(proto, importPaths), proto, ""); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+// ParseFileInfo parses the contents of a single .proto file with the
+// given import-path-relative name.
+func ParseFileInfo(name string, contents string) (*FileInfo, error) {
+	info := &FileInfo{Name: name}
+	t := &tokenizer{input: contents, line: 1}
+
+	for {
+		tok, err := t.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok.eof {
+			return info, nil
+		}
+		if tok.str {
+			return nil, fmt.Errorf("%d: unexpected string %q", tok.line, tok.text)
+		}
+		switch tok.text {
+		case ";":
+			continue
+		case "package":
+			pkg, err := t.next()
+			if err != nil {
+				return nil, err
+			}
+			if pkg.str {
+				return nil, fmt.Errorf("%d: expected package name; got %q", pkg.line, pkg.text)
+			}
+			info.Package = pkg.text
+			if err := t.expect(";"); err != nil {
+				return nil, err
+			}
+		case "import":
+			dep, err := t.next()
+			if err != nil {
+				return nil, err
+			}
+			if !dep.str && (dep.text == "public" || dep.text == "weak") {
+				if dep, err = t.next(); err != nil {
+					return nil, err
+				}
+			}
+			if !dep.str {
+				return nil, fmt.Errorf("%d: expected import filename; got %q", dep.line, dep.text)
+			}
+			value, err := t.concatStrings(dep)
+			if err != nil {
+				return nil, err
+			}
+			info.Deps = append(info.Deps, value)
+		case "option":
+			opt, err := t.next()
+			if err != nil {
+				return nil, err
+			}
+			if opt.str || opt.text != "go_package" {
+				if err := t.skipStatement(opt); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := t.expect("="); err != nil {
+				return nil, err
+			}
+			value, err := t.next()
+			if err != nil {
+				return nil, err
+			}
+			if !value.str {
+				return nil, fmt.Errorf("%d: expected go_package string; got %q", value.line, value.text)
+			}
+			if info.GoPackage, err = t.concatStrings(value); err != nil {
+				return nil, err
+			}
+		default:
+			if err := t.skipStatement(tok); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// token is a single token from a .proto file. String literals have
+// str set, and text holds their unquoted value.
+type token struct {
+	text string
+	str  bool
+	eof  bool
+	line int
+}
+
+// tokenizer splits .proto file contents into tokens, skipping
+// whitespace and comments.
+type tokenizer struct {
+	input string
+	pos   int
+	line  int
+}
+
+// next returns the next token, or a token with eof set at the end of
+// the input.
+func (t *tokenizer) next() (token, error) {
+	for t.pos < len(t.input) {
+		c := t.input[t.pos]
+		switch {
+		case c == '\n':
+			t.line++
+			t.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			t.pos++
+		case strings.HasPrefix(t.input[t.pos:], "//"):
+			end := strings.IndexByte(t.input[t.pos:], '\n')
+			if end < 0 {
+				t.pos = len(t.input)
+			} else {
+				t.pos += end
+			}
+		case strings.HasPrefix(t.input[t.pos:], "/*"):
+			end := strings.Index(t.input[t.pos+2:], "*/")
+			if end < 0 {
+				return token{}, fmt.Errorf("%d: unterminated comment", t.line)
+			}
+			t.line += strings.Count(t.input[t.pos:t.pos+2+end], "\n")
+			t.pos += end + 4
+		case c == '"' || c == '\'':
+			return t.quoted(c)
+		case isWordChar(c):
+			start := t.pos
+			for t.pos < len(t.input) && (isWordChar(t.input[t.pos]) || t.input[t.pos] == '.') {
+				t.pos++
+			}
+			return token{text: t.input[start:t.pos], line: t.line}, nil
+		default:
+			t.pos++
+			return token{text: string(c), line: t.line}, nil
+		}
+	}
+	return token{eof: true, line: t.line}, nil
+}
+
+// quoted reads a string literal delimited by quote.
+func (t *tokenizer) quoted(quote byte) (token, error) {
+	start := t.pos
+	t.pos++
+	for t.pos < len(t.input) {
+		switch t.input[t.pos] {
+		case '\\':
+			t.pos += 2
+			continue
+		case '\n':
+			return token{}, fmt.Errorf("%d: unterminated string", t.line)
+		case quote:
+			t.pos++
+			raw := t.input[start:t.pos]
+			if quote == '\'' {
+				raw = strings.Replace(raw[1:len(raw)-1], `\'`, `'`, -1)
+				raw = `"` + strings.Replace(raw, `"`, `\"`, -1) + `"`
+			}
+			value, err := strconv.Unquote(raw)
+			if err != nil {
+				return token{}, fmt.Errorf("%d: invalid string %s", t.line, t.input[start:t.pos])
+			}
+			return token{text: value, str: true, line: t.line}, nil
+		}
+		t.pos++
+	}
+	return token{}, fmt.Errorf("%d: unterminated string", t.line)
+}
+
+// expect reads the next token, and returns an error if it isn't the
+// given punctuation or keyword.
+func (t *tokenizer) expect(text string) error {
+	tok, err := t.next()
+	if err != nil {
+		return err
+	}
+	if tok.str || tok.text != text {
+		return fmt.Errorf("%d: expected %q; got %q", tok.line, text, tok.text)
+	}
+	return nil
+}
+
+// concatStrings reads any string literals following first (adjacent
+// literals are concatenated, as in C), then the terminating
+// semicolon, and returns the full string value.
+func (t *tokenizer) concatStrings(first token) (string, error) {
+	value := first.text
+	for {
+		tok, err := t.next()
+		if err != nil {
+			return "", err
+		}
+		if tok.str {
+			value += tok.text
+			continue
+		}
+		if tok.text != ";" {
+			return "", fmt.Errorf("%d: expected \";\"; got %q", tok.line, tok.text)
+		}
+		return value, nil
+	}
+}
+
+// skipStatement skips the rest of a statement that started with
+// first: up to a semicolon, or the end of a braced block.
+func (t *tokenizer) skipStatement(first token) error {
+	depth := 0
+	tok := first
+	for {
+		if tok.eof {
+			if depth > 0 {
+				return fmt.Errorf("%d: unexpected end of file", tok.line)
+			}
+			return nil
+		}
+		if !tok.str {
+			switch tok.text {
+			case ";":
+				if depth == 0 {
+					return nil
+				}
+			case "{":
+				depth++
+			case "}":
+				depth--
+				if depth == 0 {
+					return nil
+				}
+				if depth < 0 {
+					return fmt.Errorf("%d: unexpected \"}\"", tok.line)
+				}
+			}
+		}
+		var err error
+		if tok, err = t.next(); err != nil {
+			return err
+		}
+	}
+}
+
+// isWordChar returns true for characters that can appear in
+// identifiers and numbers.
+func isWordChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
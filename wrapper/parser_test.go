@@ -0,0 +1,93 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import "testing"
+
+func TestParseFileInfo(t *testing.T) {
+	tests := map[string]struct {
+		contents  string
+		pkg       string
+		goPackage string
+		deps      []string
+		err       bool
+	}{
+		"basic": {
+			contents: `
+syntax = "proto3";
+package foo.bar;
+import "a/b.proto";
+import public 'c/d.proto';
+option go_package = "example.com/foo/bar;bar";
+message Foo { string x = 1; }
+`,
+			pkg:       "foo.bar",
+			goPackage: "example.com/foo/bar;bar",
+			deps:      []string{"a/b.proto", "c/d.proto"},
+		},
+		"comments and nesting": {
+			contents: `
+// package wrong;
+/* import "wrong.proto"; */
+package foo;
+message Foo {
+  option (my.opt) = { a: 1 };
+  message package { }
+  enum E { option go_package = 3; }
+}
+option (custom) = { go_package: "wrong" };
+option go_package = "example.com/" "foo";
+import weak "x.proto";
+`,
+			pkg:       "foo",
+			goPackage: "example.com/foo",
+			deps:      []string{"x.proto"},
+		},
+		"no package": {
+			contents: `syntax = "proto2"; message Foo {}`,
+		},
+		"unterminated string": {
+			contents: `import "foo.proto;`,
+			err:      true,
+		},
+		"unterminated block": {
+			contents: `message Foo {`,
+			err:      true,
+		},
+	}
+
+	for name, tt := range tests {
+		info, err := ParseFileInfo("foo.proto", tt.contents)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: want error; got nil", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", name, err)
+			continue
+		}
+		if info.Package != tt.pkg {
+			t.Errorf("%q: want package %q; got %q", name, tt.pkg, info.Package)
+		}
+		if info.GoPackage != tt.goPackage {
+			t.Errorf("%q: want go_package %q; got %q", name, tt.goPackage, info.GoPackage)
+		}
+		if !sliceStringEqual(info.Deps, tt.deps) {
+			t.Errorf("%q: want deps %v; got %v", name, tt.deps, info.Deps)
+		}
+	}
+}
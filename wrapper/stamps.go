@@ -0,0 +1,217 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// stamps.go contains the code for incremental generation: a stamp
+// file per package records a hash of everything that went into
+// generating it, so unchanged packages can be skipped.
+
+package wrapper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// stampFilename returns the filename of the stamp file for the given
+// package.
+func (w *Wrapper) stampFilename(pkg *PackageInfo) string {
+	sum := sha256.Sum256([]byte(pkg.ComputedPackage))
+	return filepath.Join(w.StampDir, hex.EncodeToString(sum[:])+".stamp")
+}
+
+// packageStamp computes the stamp for a package: a hash of the
+// contents of its files and their transitive imports, the protoc
+// flags, and the hash of the protoc and plugin binaries.
+func (w *Wrapper) packageStamp(pkg *PackageInfo, toolsHash string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "tools %s\n", toolsHash)
+	for _, flag := range w.ProtocFlags {
+		fmt.Fprintf(h, "flag %q\n", flag)
+	}
+
+	names := map[string]string{} // name -> path
+	var walk func(name string)
+	walk = func(name string) {
+		if _, seen := names[name]; seen {
+			return
+		}
+		names[name] = ""
+		info, ok := w.infos[name]
+		if !ok {
+			return
+		}
+		if info.FullPath != "" {
+			names[name] = info.FullPath
+		} else {
+			names[name] = findInImportDirs(name, w.ImportDirs)
+		}
+		for _, dep := range info.Deps {
+			walk(dep)
+		}
+	}
+	for _, f := range pkg.Files {
+		walk(f.Name)
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		hash, err := hashFile(names[name])
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "file %q %s\n", name, hash)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// upToDate returns true if the stamp file for the package matches
+// the given stamp, and the output files it records still exist.
+func (w *Wrapper) upToDate(pkg *PackageInfo, stamp string) bool {
+	old, err := ioutil.ReadFile(w.stampFilename(pkg))
+	if err != nil {
+		return false
+	}
+	lines := strings.Split(strings.TrimSpace(string(old)), "\n")
+	if lines[0] != stamp {
+		return false
+	}
+	for _, output := range lines[1:] {
+		if _, err := os.Stat(output); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// writeStamp records the stamp for a successfully generated package,
+// followed by the paths of its protoc-gen-go outputs.
+func (w *Wrapper) writeStamp(pkg *PackageInfo, stamp string) error {
+	if err := os.MkdirAll(w.StampDir, 0777); err != nil {
+		return err
+	}
+	lines := append([]string{stamp}, w.packageOutputs(pkg)...)
+	return ioutil.WriteFile(w.stampFilename(pkg), []byte(strings.Join(lines, "\n")+"\n"), 0666)
+}
+
+// packageOutputs returns the sorted paths of the files protoc-gen-go
+// generates for the package in the --go_out output directories. The
+// names of other plugins' outputs aren't known.
+func (w *Wrapper) packageOutputs(pkg *PackageInfo) []string {
+	result := []string{}
+	for _, output := range outputDirs(w.ProtocFlags) {
+		if output.flag != "--go_out" {
+			continue
+		}
+		for name := range expectedOutputs(pkg.Files, pluginParams(w.ProtocFlags, output)) {
+			result = append(result, filepath.Join(output.dir, filepath.FromSlash(name)))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// ToolsHash returns a hash of the protoc binary and all the plugin
+// binaries implied by the given protoc flags: those named with
+// --plugin, and protoc-gen-NAME on the PATH for each --NAME_out
+// flag. Output flags for protoc's built-in generators have no plugin
+// binary, and are ignored.
+func ToolsHash(protocCommand string, protocFlags []string) (string, error) {
+	tools := map[string]string{} // name -> path
+	if path, err := exec.LookPath(protocCommand); err == nil {
+		tools[protocCommand] = path
+	} else {
+		return "", fmt.Errorf("cannot find protoc command %q: %v", protocCommand, err)
+	}
+
+	for plugin, path := range pluginBinaries(protocFlags) {
+		if path != "" {
+			tools[plugin] = path
+		} else if path, err := exec.LookPath(plugin); err == nil {
+			tools[plugin] = path
+		}
+	}
+
+	names := make([]string, 0, len(tools))
+	for name := range tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		hash, err := hashFile(tools[name])
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%q %s\n", name, hash)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// toolsHash returns the hash of the tools run by w's Executor. An
+// Executor that doesn't implement ToolsIdentifier may not run local
+// binaries at all, so only the protoc command identifies its tools.
+func (w *Wrapper) toolsHash() (string, error) {
+	if ti, ok := w.protocOptions().executor().(ToolsIdentifier); ok {
+		return ti.ToolsHash(w.ProtocCommand, w.ProtocFlags)
+	}
+	sum := sha256.Sum256([]byte(w.ProtocCommand))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// pluginBinaries returns the plugins implied by the given protoc
+// flags, mapped to their paths if given explicitly by --plugin, or to
+// "" if they have to be looked up on the PATH.
+func pluginBinaries(protocFlags []string) map[string]string {
+	plugins := map[string]string{}
+	for i := 0; i < len(protocFlags); i++ {
+		flag := protocFlags[i]
+		if !strings.HasPrefix(flag, "--") {
+			continue
+		}
+		name, value := flag[2:], ""
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value = name[:eq], name[eq+1:]
+		} else if !noValueFlags[flag] && i+1 < len(protocFlags) {
+			i++
+			value = protocFlags[i]
+		}
+		switch {
+		case name == "plugin":
+			if eq := strings.Index(value, "="); eq >= 0 {
+				plugins[value[:eq]] = value[eq+1:]
+			} else if _, found := plugins[value]; !found {
+				plugins[value] = ""
+			}
+		case name == "descriptor_set_out" || name == "dependency_out":
+			// Not generators.
+		case strings.HasSuffix(name, "_out"):
+			plugin := "protoc-gen-" + strings.TrimSuffix(name, "_out")
+			if _, found := plugins[plugin]; !found {
+				plugins[plugin] = ""
+			}
+		}
+	}
+	return plugins
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPluginBinaries(t *testing.T) {
+	tests := map[string]struct {
+		flags   string
+		plugins map[string]string
+	}{
+		"outs": {
+			"-I. --go_out=plugins=grpc:out --foo_out out",
+			map[string]string{"protoc-gen-go": "", "protoc-gen-foo": ""},
+		},
+		"explicit plugin": {
+			"--plugin=protoc-gen-go=bin/protoc-gen-go --go_out=out",
+			map[string]string{"protoc-gen-go": "bin/protoc-gen-go"},
+		},
+		"separate plugin value": {
+			"--go_out=out --plugin protoc-gen-go=bin/protoc-gen-go",
+			map[string]string{"protoc-gen-go": "bin/protoc-gen-go"},
+		},
+		"no-value flags": {
+			"--include_imports --descriptor_set_out=x.pb",
+			map[string]string{},
+		},
+	}
+
+	for name, tt := range tests {
+		got := pluginBinaries(strings.Split(tt.flags, " "))
+		if !mapStringStringEqual(got, tt.plugins) {
+			t.Errorf("%q: want %v; got %v", name, tt.plugins, got)
+		}
+	}
+}
+
+// identifyingExecutor is a recordingExecutor that identifies its tools
+// by hash.
+type identifyingExecutor struct {
+	recordingExecutor
+	hash string
+}
+
+func (e *identifyingExecutor) ToolsHash(protocCommand string, protocFlags []string) (string, error) {
+	return e.hash, nil
+}
+
+func TestStampsExecutor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stampstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	proto := filepath.Join(dir, "a.proto")
+	if err := ioutil.WriteFile(proto, []byte(`syntax = "proto3";`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	generate := func(executor Executor) {
+		w := testWrapper(&FileInfo{Name: "a/a.proto", GoPackage: "ex.com/a"})
+		w.infos["a/a.proto"].FullPath = proto
+		w.Executor = executor
+		w.ProtocCommand = "sandboxed-protoc" // Not on the PATH.
+		w.Parallelism = 1
+		w.StampDir = filepath.Join(dir, "stamps")
+		if err := w.Generate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without a ToolsIdentifier, the tools are not looked up locally.
+	executor := &recordingExecutor{}
+	generate(executor)
+	generate(executor)
+	if len(executor.commands) != 1 {
+		t.Errorf("want 1 command; got %q", executor.commands)
+	}
+
+	identifying := &identifyingExecutor{hash: "v1"}
+	for i, hash := range []string{"v1", "v1", "v2"} {
+		identifying.hash = hash
+		generate(identifying)
+		if want := []int{1, 1, 2}[i]; len(identifying.commands) != want {
+			t.Errorf("tools %q: want %d commands; got %q", hash, want, identifying.commands)
+		}
+	}
+}
+
+func TestStampsOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stampstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	proto := filepath.Join(dir, "a.proto")
+	if err := ioutil.WriteFile(proto, []byte(`syntax = "proto3";`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "gen")
+	output := filepath.Join(out, "ex.com", "a", "a.pb.go")
+
+	executor := &recordingExecutor{}
+	generate := func() {
+		w := testWrapper(&FileInfo{Name: "a/a.proto", GoPackage: "ex.com/a"})
+		w.infos["a/a.proto"].FullPath = proto
+		w.Executor = executor
+		w.ProtocCommand = "sandboxed-protoc"
+		w.ProtocFlags = []string{"--go_out=" + out}
+		w.Parallelism = 1
+		w.StampDir = filepath.Join(dir, "stamps")
+		if err := w.Generate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The executor writes nothing, so the output is missing.
+	generate()
+	generate()
+	if len(executor.commands) != 2 {
+		t.Errorf("missing output: want 2 commands; got %q", executor.commands)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(output, []byte("package a\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	generate()
+	if len(executor.commands) != 2 {
+		t.Errorf("output present: want 2 commands; got %q", executor.commands)
+	}
+
+	if err := os.Remove(output); err != nil {
+		t.Fatal(err)
+	}
+	generate()
+	if len(executor.commands) != 3 {
+		t.Errorf("output deleted: want 3 commands; got %q", executor.commands)
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// suggest.go contains the code that suggests ways to break package
+// cycles.
+
+package wrapper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CycleSuggestion describes ways of breaking a cycle.
+type CycleSuggestion struct {
+	// A small set of file-level imports whose removal breaks every
+	// cycle between the packages. This is an approximate minimum
+	// feedback arc set of the package graph, weighted by the number
+	// of file-level imports behind each package import.
+	RemoveImports []FileImport `json:"remove_imports"`
+	// Files which, if moved on their own to a new go_package, would
+	// break every cycle between the packages.
+	MovableFiles []string `json:"movable_files"`
+}
+
+// String returns a human-readable description of the suggestion.
+func (s CycleSuggestion) String() string {
+	result := []string{" suggestion: remove these imports to break the cycle:"}
+	for _, imp := range s.RemoveImports {
+		result = append(result, fmt.Sprintf("  %s imports %s", imp.From, imp.To))
+	}
+	if len(s.MovableFiles) > 0 {
+		result = append(result, " or move one of these files to a different go_package:")
+		for _, file := range s.MovableFiles {
+			result = append(result, "  "+file)
+		}
+	}
+	return strings.Join(result, "\n")
+}
+
+// SuggestCycleBreaks sets the Suggestion for each cycle in the given
+// CycleError.
+func (w *Wrapper) SuggestCycleBreaks(err *CycleError) {
+	for i := range err.Cycles {
+		suggestion := w.suggestCycleBreaks(err.Cycles[i])
+		err.Cycles[i].Suggestion = &suggestion
+	}
+}
+
+// suggestCycleBreaks computes a CycleSuggestion for the given cycle.
+func (w *Wrapper) suggestCycleBreaks(c Cycle) CycleSuggestion {
+	suggestion := CycleSuggestion{
+		RemoveImports: []FileImport{},
+		MovableFiles:  []string{},
+	}
+
+	weights := map[packageEdge]int{}
+	imports := map[packageEdge][]FileImport{}
+	for _, edge := range c.Edges {
+		e := packageEdge{edge.From, edge.To}
+		weights[e] = len(edge.Imports)
+		imports[e] = edge.Imports
+	}
+	for _, e := range feedbackArcSet(c.Packages, weights) {
+		suggestion.RemoveImports = append(suggestion.RemoveImports, imports[e]...)
+	}
+
+	for _, name := range c.Packages {
+		for _, f := range w.allPackages[name].Files {
+			if w.acyclicWithout(c.Packages, f) {
+				suggestion.MovableFiles = append(suggestion.MovableFiles, f.Name)
+			}
+		}
+	}
+	sort.Strings(suggestion.MovableFiles)
+	return suggestion
+}
+
+// acyclicWithout returns true if the graph between the given packages
+// would be acyclic if the file moved was moved into a package of its
+// own.
+func (w *Wrapper) acyclicWithout(pkgs []string, moved *FileInfo) bool {
+	const newPackage = "" // Not a valid ComputedPackage.
+	nodes := map[string]bool{newPackage: true}
+	for _, name := range pkgs {
+		nodes[name] = true
+	}
+	packageOf := func(f *FileInfo) string {
+		if f == moved {
+			return newPackage
+		}
+		return f.ComputedPackage
+	}
+
+	edges := map[packageEdge]int{}
+	for _, name := range pkgs {
+		for _, f := range w.allPackages[name].Files {
+			from := packageOf(f)
+			for _, depName := range f.Deps {
+				to := packageOf(w.infos[depName])
+				if from != to && nodes[to] {
+					edges[packageEdge{from, to}] = 1
+				}
+			}
+		}
+	}
+	nodeList := make([]string, 0, len(nodes))
+	for node := range nodes {
+		nodeList = append(nodeList, node)
+	}
+	return isAcyclic(nodeList, edges)
+}
+
+// packageEdge is an edge in the package graph.
+type packageEdge struct {
+	from, to string
+}
+
+// feedbackArcSet returns an approximate minimum-weight set of edges
+// whose removal makes the graph acyclic, sorted. It uses the greedy
+// heuristic of Eades, Lin and Smyth to order the nodes, takes the
+// edges pointing backwards in that order, and then puts back any of
+// those edges that don't re-introduce a cycle.
+func feedbackArcSet(nodes []string, weights map[packageEdge]int) []packageEdge {
+	remaining := map[string]bool{}
+	for _, node := range nodes {
+		remaining[node] = true
+	}
+	sorted := append([]string(nil), nodes...)
+	sort.Strings(sorted)
+
+	// weight returns the total weight of the edges out of (or into) a
+	// node, considering only remaining nodes.
+	weight := func(node string, out bool) int {
+		total := 0
+		for e, w := range weights {
+			if out && e.from == node && remaining[e.to] && e.to != node {
+				total += w
+			}
+			if !out && e.to == node && remaining[e.from] && e.from != node {
+				total += w
+			}
+		}
+		return total
+	}
+
+	var head, tail []string
+	for len(remaining) > 0 {
+		for changed := true; changed; {
+			changed = false
+			for _, node := range sorted {
+				if remaining[node] && weight(node, true) == 0 {
+					tail = append([]string{node}, tail...)
+					delete(remaining, node)
+					changed = true
+				}
+			}
+		}
+		for changed := true; changed; {
+			changed = false
+			for _, node := range sorted {
+				if remaining[node] && weight(node, false) == 0 {
+					head = append(head, node)
+					delete(remaining, node)
+					changed = true
+				}
+			}
+		}
+		best, bestDelta := "", 0
+		for _, node := range sorted {
+			if !remaining[node] {
+				continue
+			}
+			if delta := weight(node, true) - weight(node, false); best == "" || delta > bestDelta {
+				best, bestDelta = node, delta
+			}
+		}
+		if best != "" {
+			head = append(head, best)
+			delete(remaining, best)
+		}
+	}
+
+	position := map[string]int{}
+	for i, node := range append(head, tail...) {
+		position[node] = i
+	}
+	kept := map[packageEdge]int{}
+	backward := []packageEdge{}
+	for e, w := range weights {
+		if position[e.from] > position[e.to] {
+			backward = append(backward, e)
+		} else {
+			kept[e] = w
+		}
+	}
+
+	// Try to put back the heaviest edges first.
+	sort.Slice(backward, func(i, j int) bool {
+		wi, wj := weights[backward[i]], weights[backward[j]]
+		if wi != wj {
+			return wi > wj
+		}
+		return lessEdge(backward[i], backward[j])
+	})
+	result := []packageEdge{}
+	for _, e := range backward {
+		kept[e] = weights[e]
+		if !isAcyclic(nodes, kept) {
+			delete(kept, e)
+			result = append(result, e)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return lessEdge(result[i], result[j]) })
+	return result
+}
+
+// isAcyclic returns true if the graph has no cycles, using Kahn's
+// algorithm. Edges to or from nodes not in the list are ignored.
+func isAcyclic(nodes []string, edges map[packageEdge]int) bool {
+	inDegree := map[string]int{}
+	for _, node := range nodes {
+		inDegree[node] = 0
+	}
+	for e := range edges {
+		_, fromOK := inDegree[e.from]
+		_, toOK := inDegree[e.to]
+		if fromOK && toOK {
+			inDegree[e.to]++
+		}
+	}
+	queue := []string{}
+	for node, degree := range inDegree {
+		if degree == 0 {
+			queue = append(queue, node)
+		}
+	}
+	visited := 0
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		visited++
+		for e := range edges {
+			if e.from != node {
+				continue
+			}
+			if _, ok := inDegree[e.to]; !ok {
+				continue
+			}
+			inDegree[e.to]--
+			if inDegree[e.to] == 0 {
+				queue = append(queue, e.to)
+			}
+		}
+	}
+	return visited == len(inDegree)
+}
+
+// lessEdge orders edges by source, then destination.
+func lessEdge(a, b packageEdge) bool {
+	if a.from != b.from {
+		return a.from < b.from
+	}
+	return a.to < b.to
+}
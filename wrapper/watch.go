@@ -0,0 +1,219 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// watch.go contains the code for watching import directories and
+// regenerating packages whose .proto files change.
+
+package wrapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// watchQuietPeriod is how long Watch waits after a change for further
+// changes, so that a burst of changes (such as an editor's save, or a
+// branch switch) results in a single regeneration.
+const watchQuietPeriod = 200 * time.Millisecond
+
+// dirWatcher reports changes to .proto files in a tree of directories.
+type dirWatcher struct {
+	events chan string // Paths of .proto files created, changed or removed.
+	errors chan error  // Errors encountered while watching.
+	close  func() error
+}
+
+// Watch watches the import directories containing the specified proto
+// files, and calls Regenerate each time .proto files in them change,
+// until stop is closed. Errors from Regenerate are printed, and
+// watching continues. Generate should normally be called first.
+func (w *Wrapper) Watch(stop <-chan struct{}) error {
+	if !w.initCalled {
+		return errors.New("Init() must be called before Watch()")
+	}
+	watcher, err := newDirWatcher(w.importDirsUsed())
+	if err != nil {
+		return err
+	}
+	defer watcher.close()
+
+	// Cancel any regeneration in progress when stop is closed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		changed := map[string]bool{}
+		select {
+		case <-stop:
+			return nil
+		case err := <-watcher.errors:
+			return err
+		case path := <-watcher.events:
+			changed[path] = true
+		}
+
+		timer := time.NewTimer(watchQuietPeriod)
+	QUIET:
+		for {
+			select {
+			case <-stop:
+				timer.Stop()
+				return nil
+			case err := <-watcher.errors:
+				timer.Stop()
+				return err
+			case path := <-watcher.events:
+				changed[path] = true
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(watchQuietPeriod)
+			case <-timer.C:
+				break QUIET
+			}
+		}
+
+		paths := make([]string, 0, len(changed))
+		for path := range changed {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		if err := w.regenerate(ctx, paths); err != nil {
+			w.logger().Error("Regenerating failed", "error", err)
+		}
+	}
+}
+
+// Regenerate updates the file information for the given .proto files,
+// which may have been added, changed or removed, and regenerates the
+// packages being generated that contain them, or that transitively
+// import a package that contains them. Files that aren't (or are no
+// longer) found alongside the specified proto files, or that aren't
+// in any of the import directories, are ignored. If ChangedFiles is
+// set, only the packages it affects are regenerated.
+func (w *Wrapper) Regenerate(changed []string) error {
+	return w.regenerate(context.Background(), changed)
+}
+
+// regenerate implements Regenerate, stopping if the context is done.
+func (w *Wrapper) regenerate(ctx context.Context, changed []string) error {
+	if !w.initCalled {
+		return errors.New("Init() must be called before Regenerate()")
+	}
+	inImportDirs := []string{}
+	for _, path := range changed {
+		if _, ok := fileDescriptorName(path, w.ImportDirs); ok {
+			inImportDirs = append(inImportDirs, path)
+		}
+	}
+	changed = inImportDirs
+
+	affected := map[string]bool{}
+	for _, path := range changed {
+		if info, ok := w.infos[FileDescriptorName(path, w.ImportDirs)]; ok && info.FullPath != "" {
+			affected[info.ComputedPackage] = true
+		}
+	}
+	if err := w.reload(ctx, changed); err != nil {
+		return err
+	}
+	for _, path := range changed {
+		if info, ok := w.infos[FileDescriptorName(path, w.ImportDirs)]; ok && info.FullPath != "" {
+			affected[info.ComputedPackage] = true
+		}
+	}
+
+	packages := map[string]*PackageInfo{}
+	for name := range w.dependents(affected) {
+		if pkg, ok := w.packages[name]; ok {
+			packages[name] = pkg
+		}
+	}
+	if len(packages) == 0 {
+		return nil
+	}
+	if err := w.CheckCycles(); err != nil {
+		return err
+	}
+	return w.generatePackages(ctx, packages)
+}
+
+// reload re-reads the set of proto files, and the file information
+// for the changed ones and any new ones. The Wrapper is only updated
+// if that succeeds.
+func (w *Wrapper) reload(ctx context.Context, changed []string) error {
+	for _, file := range w.ProtoFiles {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			return fmt.Errorf("input %q does not exist", file)
+		}
+	}
+	allProtos, err := w.findAllProtos()
+	if err != nil {
+		return err
+	}
+
+	infos := make(map[string]*FileInfo, len(w.infos))
+	for name, info := range w.infos {
+		infos[name] = info
+	}
+	for _, path := range changed {
+		delete(infos, FileDescriptorName(path, w.ImportDirs))
+	}
+	stale := []string{}
+	for _, proto := range allProtos {
+		if _, ok := infos[FileDescriptorName(proto, w.ImportDirs)]; !ok {
+			stale = append(stale, proto)
+		}
+	}
+	if len(stale) > 0 {
+		fresh, err := w.getFileInfos(ctx, stale)
+		if err != nil {
+			return fmt.Errorf("cannot get .proto file information: %w", err)
+		}
+		computeGoLocations(fresh, w.logger())
+		for name, info := range fresh {
+			if _, ok := infos[name]; !ok {
+				infos[name] = info
+			}
+		}
+	}
+	for _, info := range infos {
+		for _, dep := range info.Deps {
+			if _, ok := infos[dep]; !ok {
+				return fmt.Errorf("%s imports missing file %s", info.Name, dep)
+			}
+		}
+	}
+	AnnotateFullPaths(infos, allProtos, w.ImportDirs)
+
+	if err := w.setInfos(infos); err != nil {
+		return err
+	}
+	w.allProtos = allProtos
+	if w.ChangedFiles != nil {
+		return w.restrictToChanged()
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// watch_linux.go contains the inotify-based implementation of
+// dirWatcher.
+
+package wrapper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask is the set of inotify events we watch directories for.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+// inotify is the state of an inotify-based dirWatcher.
+type inotify struct {
+	fd   int
+	file *os.File       // fd, wrapped so that reads use the runtime poller and Close interrupts them.
+	dirs map[int]string // Watched directories, by watch descriptor.
+	w    *dirWatcher
+	done chan struct{}
+}
+
+// newDirWatcher returns a dirWatcher watching the given directories
+// and all directories below them, including those created later.
+func newDirWatcher(dirs []string) (*dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	in := &inotify{
+		fd:   fd,
+		file: os.NewFile(uintptr(fd), "inotify"),
+		dirs: map[int]string{},
+		w: &dirWatcher{
+			events: make(chan string),
+			errors: make(chan error, 1),
+		},
+		done: make(chan struct{}),
+	}
+	in.w.close = func() error {
+		close(in.done)
+		return in.file.Close()
+	}
+	for _, dir := range dirs {
+		if err := in.addTree(dir, false); err != nil {
+			in.file.Close()
+			return nil, err
+		}
+	}
+	go in.read()
+	return in.w, nil
+}
+
+// addTree adds watches for dir and all directories below it. If
+// report is true, the .proto files found are sent as events: they
+// were created before the watch was added.
+func (in *inotify) addTree(dir string, report bool) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			if report && strings.HasSuffix(path, ".proto") && !in.send(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(in.fd, path, inotifyMask)
+		if err != nil {
+			return &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+		}
+		in.dirs[wd] = path
+		return nil
+	})
+}
+
+// send sends an event, returning false if the watcher was closed.
+func (in *inotify) send(path string) bool {
+	select {
+	case in.w.events <- path:
+		return true
+	case <-in.done:
+		return false
+	}
+}
+
+// read reads and dispatches inotify events until the watcher is
+// closed.
+func (in *inotify) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := in.file.Read(buf)
+		if err != nil {
+			select {
+			case <-in.done:
+			default:
+				in.w.errors <- err
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				in.w.errors <- errors.New("inotify event queue overflowed")
+				return
+			}
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(in.dirs, int(event.Wd))
+				continue
+			}
+			dir, ok := in.dirs[int(event.Wd)]
+			if !ok {
+				continue
+			}
+			path := filepath.Join(dir, strings.TrimRight(string(nameBytes), "\x00"))
+			if event.Mask&syscall.IN_ISDIR != 0 {
+				if event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					if err := in.addTree(path, true); err != nil {
+						in.w.errors <- err
+						return
+					}
+				}
+				continue
+			}
+			if strings.HasSuffix(path, ".proto") && !in.send(path) {
+				return
+			}
+		}
+	}
+}
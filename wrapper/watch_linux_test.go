@@ -0,0 +1,70 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirWatcher(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "a"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := newDirWatcher([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.close()
+
+	// expect waits for an event for path, skipping any others, such
+	// as a second event for a file that was also found when its
+	// directory was added.
+	expect := func(path string) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case got := <-watcher.events:
+				if got == path {
+					return
+				}
+			case err := <-watcher.errors:
+				t.Fatal(err)
+			case <-timeout:
+				t.Fatalf("no event for %s", path)
+			}
+		}
+	}
+
+	existing := filepath.Join(dir, "a", "a.proto")
+	if err := ioutil.WriteFile(existing, []byte("syntax = \"proto3\";\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	expect(existing)
+
+	created := filepath.Join(dir, "b", "c", "c.proto")
+	if err := os.MkdirAll(filepath.Dir(created), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(created, []byte("syntax = \"proto3\";\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	expect(created)
+}
@@ -0,0 +1,29 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+// watch_other.go contains the fallback dirWatcher for platforms
+// without inotify.
+
+package wrapper
+
+import "errors"
+
+// newDirWatcher returns an error: watching is only supported on
+// Linux.
+func newDirWatcher(dirs []string) (*dirWatcher, error) {
+	return nil, errors.New("watching for changes is only supported on Linux")
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/square/goprotowrap/wrapper"
+	"github.com/square/goprotowrap/wrapper/wrappertest"
+)
+
+func TestRegenerate(t *testing.T) {
+	tree := wrappertest.NewTree(t).
+		Add("a/a.proto", "ex.com/a", "b/b.proto").
+		Add("b/b.proto", "ex.com/b").
+		Add("b/b2.proto", "ex.com/b").
+		Add("c/c.proto", "ex.com/c")
+	protoc := wrappertest.NewProtoc(tree)
+	w := &wrapper.Wrapper{
+		ProtocCommand: "protoc",
+		Executor:      protoc,
+		Parallelism:   1,
+		ProtocFlags:   []string{"-I" + tree.Dir, "--go_out=gen"},
+		ImportDirs:    []string{tree.Dir},
+		ProtoFiles:    []string{tree.Path("a/a.proto"), tree.Path("b/b.proto"), tree.Path("c/c.proto")},
+	}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name    string
+		change  func()
+		changed string
+		want    []string
+	}{
+		{"changed", func() { tree.Add("b/b2.proto", "ex.com/b") }, "b/b2.proto", []string{"ex.com/a", "ex.com/b"}},
+		{"added", func() { tree.Add("b/b3.proto", "ex.com/b") }, "b/b3.proto", []string{"ex.com/a", "ex.com/b"}},
+		{"import removed", func() { tree.Add("a/a.proto", "ex.com/a") }, "a/a.proto", []string{"ex.com/a"}},
+		{"deleted", func() {
+			if err := os.Remove(tree.Path("b/b2.proto")); err != nil {
+				t.Fatal(err)
+			}
+		}, "b/b2.proto", []string{"ex.com/b"}},
+	}
+	for _, step := range steps {
+		protoc.Reset()
+		step.change()
+		if err := w.Regenerate([]string{tree.Path(step.changed)}); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		want := map[string][]string{}
+		for _, pkg := range step.want {
+			want[pkg] = nil
+		}
+		wrappertest.AssertGenerated(t, protoc, want)
+	}
+	wrappertest.AssertFiles(t, protoc, "ex.com/b", "b/b.proto", "b/b3.proto")
+
+	// A change that can't be loaded leaves the Wrapper as it was.
+	before := w.Structure()
+	protoc.Reset()
+	tree.Add("c/c.proto", "ex.com/c", "missing/missing.proto")
+	if err := w.Regenerate([]string{tree.Path("c/c.proto")}); err == nil {
+		t.Fatal("want error for missing import")
+	}
+	if after := w.Structure(); !reflect.DeepEqual(after, before) {
+		t.Errorf("want structure unchanged after failed reload; got %+v, was %+v", after, before)
+	}
+	wrappertest.AssertGenerated(t, protoc, map[string][]string{})
+}
+
+func TestRegenerateIgnored(t *testing.T) {
+	tree := wrappertest.NewTree(t).
+		Add("a/a.proto", "ex.com/a", "b/b.proto").
+		Add("b/b.proto", "ex.com/b").
+		Add("c/c.proto", "ex.com/c")
+	protoc := wrappertest.NewProtoc(tree)
+	w := &wrapper.Wrapper{
+		ProtocCommand: "protoc",
+		Executor:      protoc,
+		Parallelism:   1,
+		ProtocFlags:   []string{"-I" + tree.Dir, "--go_out=gen"},
+		ImportDirs:    []string{tree.Dir},
+		ProtoFiles:    tree.Paths(),
+		ChangedFiles:  []string{tree.Path("c/c.proto")},
+	}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files outside the import directories are ignored.
+	outside := filepath.Join(filepath.Dir(tree.Dir), "elsewhere", "x.proto")
+	if err := w.Regenerate([]string{outside}); err != nil {
+		t.Fatal(err)
+	}
+	wrappertest.AssertGenerated(t, protoc, map[string][]string{})
+
+	// So are packages ChangedFiles doesn't affect, after reloading.
+	tree.Add("b/b2.proto", "ex.com/b")
+	if err := w.Regenerate([]string{tree.Path("b/b2.proto")}); err != nil {
+		t.Fatal(err)
+	}
+	wrappertest.AssertGenerated(t, protoc, map[string][]string{})
+	tree.Add("c/c2.proto", "ex.com/c")
+	if err := w.Regenerate([]string{tree.Path("c/c2.proto")}); err != nil {
+		t.Fatal(err)
+	}
+	wrappertest.AssertGenerated(t, protoc, map[string][]string{"ex.com/c": nil})
+}
@@ -17,6 +17,8 @@
 package wrapper
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -24,6 +26,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 // defaultProtocCommand is the default command used to call protoc.
@@ -31,13 +34,27 @@ const defaultProtocCommand = "protoc"
 
 // The wrapper object.
 type Wrapper struct {
-	ProtocCommand string   // The command to call to run protoc.
-	Parallelism   int      // Number of simultaneous calls to make to protoc when generating.
-	ProtocFlags   []string // Flags to pass to protoc.
-	ImportDirs    []string // Base directories in which .proto files reside.
-	ProtoFiles    []string // The list of .proto files to generate code for.
-	NoExpand      bool     // If true, don't search for other protos in import directories.
-	PrintOnly     bool     // If true, don't generate: just print the protoc commandlines that would be called.
+	ProtocCommand   string   // The command to call to run protoc.
+	Parallelism     int      // Number of simultaneous calls to make to protoc when collecting and generating.
+	ProtocFlags     []string // Flags to pass to protoc.
+	ImportDirs      []string // Base directories in which .proto files reside.
+	ProtoFiles      []string // The list of .proto files to generate code for.
+	NoExpand        bool     // If true, don't search for other protos in import directories.
+	Include         []string // If set, only use other protos found in import directories that match one of these globs.
+	Exclude         []string // Don't use, or walk into, other protos or directories in import directories that match any of these globs.
+	PrintOnly       bool     // If true, don't generate: just print the protoc commandlines that would be called.
+	Parser          string   // How to get .proto file information: ParserProtoc (the default) or ParserGo.
+	CacheDir        string   // If set, the directory used to cache parsed .proto file information between runs.
+	StampDir        string   // If set, the directory holding per-package stamps; packages whose stamp is unchanged are not regenerated.
+	Force           bool     // If true, regenerate every package even if its stamp is unchanged.
+	DependencyOrder bool     // If true, only generate a package once all the packages it imports have been generated.
+	Atomic          bool     // If true, generate into staging directories, and only move changed files into the output directories if every package succeeds.
+	KeepGoing       bool     // If true, generate every package even if some fail, and return all the errors.
+	ChangedFiles    []string // If non-nil, only generate (and check for cycles) the packages affected by changes to these files; see AffectedPackages.
+
+	ProtocTimeout time.Duration // If non-zero, protoc calls that take longer than this are killed.
+	Executor      Executor      // Runs protoc. If nil, a LocalExecutor is used.
+	Logger        Logger        // Where progress, warnings and debugging output go. If nil, NewLogger(os.Stdout, os.Stderr, slog.LevelInfo) is used.
 
 	allProtos   []string                // All proto files: those specified, plus those found alongside them.
 	infos       map[string]*FileInfo    // A map of filename to FileInfo struct for all proto files we care about in this run.
@@ -52,6 +69,12 @@ type Wrapper struct {
 
 // Init must be called before any of the methods that do anything.
 func (w *Wrapper) Init() error {
+	return w.InitContext(context.Background())
+}
+
+// InitContext is like Init, but kills any protoc calls if the context
+// is done.
+func (w *Wrapper) InitContext(ctx context.Context) error {
 	if len(w.ImportDirs) == 0 {
 		return errors.New("at least one import directory required")
 	}
@@ -83,59 +106,130 @@ func (w *Wrapper) Init() error {
 	if w.ProtocCommand == "" {
 		w.ProtocCommand = defaultProtocCommand
 	}
+	switch w.Parser {
+	case "":
+		w.Parser = ParserProtoc
+	case ParserProtoc, ParserGo:
+	default:
+		return fmt.Errorf("unknown parser %q; want %q or %q", w.Parser, ParserProtoc, ParserGo)
+	}
+
+	var err error
+	if w.allProtos, err = w.findAllProtos(); err != nil {
+		return err
+	}
+	infos, err := w.getFileInfos(ctx, w.allProtos)
+	if err != nil {
+		return fmt.Errorf("cannot get .proto file information: %w", err)
+	}
+	AnnotateFullPaths(infos, w.allProtos, w.ImportDirs)
+	computeGoLocations(infos, w.logger())
+	if err := w.setInfos(infos); err != nil {
+		return err
+	}
+	if w.ChangedFiles != nil {
+		if err := w.restrictToChanged(); err != nil {
+			return err
+		}
+	}
 
+	w.initCalled = true
+	return nil
+}
+
+// findAllProtos returns the specified proto files, plus (unless
+// NoExpand is set) all the proto files found alongside them.
+func (w *Wrapper) findAllProtos() ([]string, error) {
 	// Get the list of actually-used import directories.
 	dirs := w.importDirsUsed()
 
 	expanded := []string{}
 	// Unless asked not to, find all proto files with common import directory ancestors.
 	if !w.NoExpand {
-		neighbors, err := ProtosBelow(dirs)
+		neighbors, err := FindProtos(dirs, w.Include, w.Exclude)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		expanded = Disjoint(w.ProtoFiles, neighbors)
 	}
 
-	w.allProtos = make([]string, len(w.ProtoFiles), len(w.ProtoFiles)+len(expanded))
-	copy(w.allProtos, w.ProtoFiles)
-	w.allProtos = append(w.allProtos, expanded...)
-	var err error
-	w.infos, err = GetFileInfos(w.ImportDirs, w.allProtos, w.ProtocCommand)
-	if err != nil {
-		return fmt.Errorf("cannot get .proto file information: %v", err)
-	}
-
-	AnnotateFullPaths(w.infos, w.allProtos, w.ImportDirs)
-	ComputeGoLocations(w.infos)
+	allProtos := make([]string, len(w.ProtoFiles), len(w.ProtoFiles)+len(expanded))
+	copy(allProtos, w.ProtoFiles)
+	return append(allProtos, expanded...), nil
+}
 
+// setInfos sets the FileInfos for this run, and computes the packages
+// from them.
+func (w *Wrapper) setInfos(infos map[string]*FileInfo) error {
 	neededPackages := map[string]struct{}{}
 	for _, proto := range w.ProtoFiles {
-		info, ok := w.infos[FileDescriptorName(proto, w.ImportDirs)]
+		info, ok := infos[FileDescriptorName(proto, w.ImportDirs)]
 		if !ok {
 			return fmt.Errorf("missing file info for %q.\n", proto)
 		}
 		neededPackages[info.ComputedPackage] = struct{}{}
 	}
 
-	w.allPackages, err = CollectPackages(w.infos, w.ProtoFiles, w.ImportDirs)
+	allPackages, err := CollectPackages(infos, w.ProtoFiles, w.ImportDirs)
 	if err != nil {
 		return fmt.Errorf("cannot collect package information: %v", err)
 	}
 
-	w.packages = map[string]*PackageInfo{}
+	packages := map[string]*PackageInfo{}
 	for pkgName := range neededPackages {
-		pkg, ok := w.allPackages[pkgName]
+		pkg, ok := allPackages[pkgName]
 		if !ok {
 			return fmt.Errorf("cannot find package information for %q", pkgName)
 		}
-		w.packages[pkgName] = pkg
+		packages[pkgName] = pkg
 	}
 
-	w.initCalled = true
+	w.infos, w.allPackages, w.packages = infos, allPackages, packages
+	w.sccs = nil
 	return nil
 }
 
+// getFileInfos gets the FileInfo struct for every given proto, using
+// the configured parser, and using and updating the on-disk cache if
+// CacheDir is set.
+func (w *Wrapper) getFileInfos(ctx context.Context, protos []string) (map[string]*FileInfo, error) {
+	parallelism := w.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	parse := func(protos []string) (map[string]*FileInfo, error) {
+		if w.Parser == ParserGo {
+			return ParseFileInfos(w.ImportDirs, protos)
+		}
+		return GetFileInfosContext(ctx, w.protocOptions(), w.ImportDirs, protos, w.ProtocCommand, parallelism)
+	}
+	if w.CacheDir == "" {
+		return parse(protos)
+	}
+	cache, err := LoadFileInfoCache(w.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	infos, misses := cache.Lookup(protos, w.ImportDirs)
+	if len(misses) == 0 {
+		return infos, nil
+	}
+	fresh, err := parse(misses)
+	if err != nil {
+		return nil, err
+	}
+	for name, info := range fresh {
+		infos[name] = info
+	}
+	if err := cache.Update(fresh, w.ImportDirs); err != nil {
+		return nil, err
+	}
+	if err := cache.Save(); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
 // inImportDir returns true if the given file has a lexicographical
 // prefix of one of the import directories.
 func (w *Wrapper) inImportDir(file string) bool {
@@ -188,30 +282,152 @@ func (w *Wrapper) PrintStructure(writer io.Writer) {
 	}
 }
 
+// StructureFile describes a single file in the output of
+// PrintStructureJSON.
+type StructureFile struct {
+	Name      string `json:"name"`
+	FullPath  string `json:"full_path,omitempty"`
+	Package   string `json:"package"`
+	GoPackage string `json:"go_package"`
+}
+
+// StructurePackage describes a single package in the output of
+// PrintStructureJSON.
+type StructurePackage struct {
+	ComputedPackage string          `json:"computed_package"`
+	Files           []StructureFile `json:"files"`
+	Deps            []StructureFile `json:"deps"`
+}
+
+// Structure returns the computed structure: the packages, sorted by
+// name, each with their files and deps sorted by name.
+func (w *Wrapper) Structure() []StructurePackage {
+	structureFiles := func(files []*FileInfo) []StructureFile {
+		result := make([]StructureFile, 0, len(files))
+		for _, file := range files {
+			result = append(result, StructureFile{
+				Name:      file.Name,
+				FullPath:  file.FullPath,
+				Package:   file.Package,
+				GoPackage: file.GoPackage,
+			})
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+		return result
+	}
+
+	result := []StructurePackage{}
+	for _, pkg := range w.packagesInOrder() {
+		result = append(result, StructurePackage{
+			ComputedPackage: pkg.ComputedPackage,
+			Files:           structureFiles(pkg.Files),
+			Deps:            structureFiles(pkg.Deps),
+		})
+	}
+	return result
+}
+
+// PrintStructureJSON writes the computed structure to the given
+// io.Writer as a JSON document.
+func (w *Wrapper) PrintStructureJSON(writer io.Writer) error {
+	if !w.initCalled {
+		return errors.New("Init() must be called before PrintStructureJSON()")
+	}
+	enc := json.NewEncoder(writer)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Packages []StructurePackage `json:"packages"`
+	}{w.Structure()})
+}
+
 // Generate actually generates the output files.
 func (w *Wrapper) Generate() error {
+	return w.GenerateContext(context.Background())
+}
+
+// GenerateContext is like Generate, but stops if the context is done,
+// killing any protoc calls in progress. The error returned is then a
+// *CanceledError listing the packages left unfinished.
+func (w *Wrapper) GenerateContext(ctx context.Context) error {
 	if !w.initCalled {
 		return errors.New("Init() must be called before Generate()")
 	}
+	return w.generatePackages(ctx, w.packages)
+}
+
+// generatePackages generates the given packages, via staging
+// directories if Atomic is set.
+func (w *Wrapper) generatePackages(ctx context.Context, packages map[string]*PackageInfo) error {
+	if w.Atomic && !w.PrintOnly {
+		return w.generateAtomically(ctx, packages)
+	}
+	return w.generate(ctx, packages, w.ProtocFlags, nil)
+}
+
+// generate generates the given packages, passing protoc the given
+// flags, which may differ from ProtocFlags in their output
+// directories. If stamps is non-nil, the stamps of generated packages
+// are recorded in it instead of being written. If the context is
+// done, the error returned is a *CanceledError.
+func (w *Wrapper) generate(ctx context.Context, packages map[string]*PackageInfo, protocFlags []string, stamps map[*PackageInfo]string) error {
 	if w.Parallelism < 1 {
 		return fmt.Errorf("parallelism cannot be < 1; got %d", w.Parallelism)
 	}
-	parallelism := len(w.packages)
+	parallelism := len(packages)
 	if w.Parallelism < parallelism {
 		parallelism = w.Parallelism
 	}
 
+	toolsHash := ""
+	if w.StampDir != "" {
+		var err error
+		if toolsHash, err = w.toolsHash(); err != nil {
+			return err
+		}
+	}
+
 	pkgChan := make(chan *PackageInfo)
 
+	// doneChan is only used when generating in dependency order.
+	var doneChan chan *PackageInfo
+	if w.DependencyOrder {
+		doneChan = make(chan *PackageInfo, len(packages))
+	}
+
 	errChan := make(chan error, parallelism)
 	var wg sync.WaitGroup
+	var stampsMu, errorsMu sync.Mutex
+	var packageErrors []*PackageError // Only used with KeepGoing.
+	finished := map[string]bool{}     // Guarded by errorsMu.
 	wg.Add(parallelism)
 	for i := 0; i < parallelism; i++ {
 		go func() {
 			for pkg := range pkgChan {
-				fmt.Printf("Generating package %s\n", pkg.ComputedPackage)
-				if err := Generate(pkg, w.ImportDirs, w.ProtocCommand, w.ProtocFlags, w.PrintOnly); err != nil {
-					errChan <- fmt.Errorf("error generating package %s: %v\n", pkg.ComputedPackage, err)
+				stamp, pkgErr := w.generatePackage(ctx, pkg, toolsHash, protocFlags)
+				if pkgErr == nil && stamp != "" {
+					if stamps != nil {
+						stampsMu.Lock()
+						stamps[pkg] = stamp
+						stampsMu.Unlock()
+					} else if err := w.writeStamp(pkg, stamp); err != nil {
+						pkgErr = &PackageError{Package: pkg.ComputedPackage, Err: fmt.Errorf("error writing stamp for package %s: %v\n", pkg.ComputedPackage, err)}
+					}
+				}
+				if pkgErr != nil && !w.KeepGoing {
+					errChan <- pkgErr
+					continue
+				}
+				errorsMu.Lock()
+				if pkgErr != nil {
+					packageErrors = append(packageErrors, pkgErr)
+				} else {
+					finished[pkg.ComputedPackage] = true
+				}
+				errorsMu.Unlock()
+				// With KeepGoing, failed packages count as done, so
+				// their dependents are still generated.
+				if doneChan != nil {
+					doneChan <- pkg
 				}
 			}
 			wg.Done()
@@ -219,12 +435,18 @@ func (w *Wrapper) Generate() error {
 	}
 
 	var err error
-OUTER:
-	for _, pkg := range w.packagesInOrder() {
-		select {
-		case pkgChan <- pkg:
-		case err = <-errChan:
-			break OUTER
+	if w.DependencyOrder {
+		err = w.dispatchInDependencyOrder(ctx, packages, pkgChan, doneChan, errChan)
+	} else {
+	OUTER:
+		for _, pkg := range sortedPackages(packages) {
+			select {
+			case pkgChan <- pkg:
+			case err = <-errChan:
+				break OUTER
+			case <-ctx.Done():
+				break OUTER
+			}
 		}
 	}
 	close(pkgChan)
@@ -233,33 +455,127 @@ OUTER:
 	case err = <-errChan:
 	default:
 	}
+	if ctx.Err() != nil {
+		canceled := &CanceledError{Err: ctx.Err()}
+		for _, pkg := range sortedPackages(packages) {
+			if !finished[pkg.ComputedPackage] {
+				canceled.Unfinished = append(canceled.Unfinished, pkg.ComputedPackage)
+			}
+		}
+		return canceled
+	}
+	if err == nil && len(packageErrors) > 0 {
+		sort.Slice(packageErrors, func(i, j int) bool { return packageErrors[i].Package < packageErrors[j].Package })
+		return &GenerateError{Errors: packageErrors}
+	}
 	return err
 }
 
-// packagesInOrder returns the list of packages, sorted by name.
-func (w *Wrapper) packagesInOrder() []*PackageInfo {
-	result := make([]*PackageInfo, 0, len(w.packages))
-	names := make([]string, 0, len(w.packages))
-	for name := range w.packages {
-		names = append(names, name)
+// dispatchInDependencyOrder sends the packages to pkgChan, only sending
+// each one once every package it imports has been reported on
+// doneChan. Packages that are not being generated, and packages in
+// the same strongly-connected component, are not waited for. It
+// returns the first error received on errChan, or the context's error
+// if it is done.
+func (w *Wrapper) dispatchInDependencyOrder(ctx context.Context, packages map[string]*PackageInfo, pkgChan chan<- *PackageInfo, doneChan <-chan *PackageInfo, errChan <-chan error) error {
+	component := map[string]int{}
+	for i, scc := range w.components() {
+		for _, pkg := range scc {
+			component[pkg.ComputedPackage] = i
+		}
 	}
-	sort.Strings(names)
-	for _, name := range names {
-		result = append(result, w.packages[name])
+
+	waitingFor := map[string]int{}
+	dependents := map[string][]*PackageInfo{}
+	ready := []*PackageInfo{}
+	for _, pkg := range sortedPackages(packages) {
+		for _, dep := range pkg.ImportedPackageComputedNames() {
+			if _, ok := packages[dep]; !ok || component[dep] == component[pkg.ComputedPackage] {
+				continue
+			}
+			waitingFor[pkg.ComputedPackage]++
+			dependents[dep] = append(dependents[dep], pkg)
+		}
+		if waitingFor[pkg.ComputedPackage] == 0 {
+			ready = append(ready, pkg)
+		}
 	}
-	return result
+
+	inFlight := 0
+	for len(ready) > 0 || inFlight > 0 {
+		// A nil channel blocks forever, disabling the send case.
+		var sendChan chan<- *PackageInfo
+		var next *PackageInfo
+		if len(ready) > 0 {
+			sendChan = pkgChan
+			next = ready[0]
+		}
+		select {
+		case sendChan <- next:
+			ready = ready[1:]
+			inFlight++
+		case done := <-doneChan:
+			inFlight--
+			for _, pkg := range dependents[done.ComputedPackage] {
+				waitingFor[pkg.ComputedPackage]--
+				if waitingFor[pkg.ComputedPackage] == 0 {
+					ready = append(ready, pkg)
+				}
+			}
+		case err := <-errChan:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// generatePackage generates a single package, skipping it if stamps
+// are in use and its stamp is unchanged. It returns the stamp to
+// record for the package, if any.
+func (w *Wrapper) generatePackage(ctx context.Context, pkg *PackageInfo, toolsHash string, protocFlags []string) (string, *PackageError) {
+	stamp := ""
+	if w.StampDir != "" {
+		var err error
+		if stamp, err = w.packageStamp(pkg, toolsHash); err != nil {
+			return "", &PackageError{Package: pkg.ComputedPackage, Err: fmt.Errorf("error computing stamp for package %s: %v\n", pkg.ComputedPackage, err)}
+		}
+		if !w.Force && w.upToDate(pkg, stamp) {
+			w.logger().Info("Skipping unchanged package", "package", pkg.ComputedPackage)
+			return "", nil
+		}
+	}
+	w.logger().Info("Generating package", "package", pkg.ComputedPackage)
+	if err := GenerateContext(ctx, w.protocOptions(), pkg, w.ImportDirs, w.ProtocCommand, protocFlags, w.PrintOnly); err != nil {
+		return "", &PackageError{Package: pkg.ComputedPackage, Err: fmt.Errorf("error generating package %s: %w\n", pkg.ComputedPackage, err)}
+	}
+	if w.PrintOnly {
+		return "", nil
+	}
+	return stamp, nil
+}
+
+// packagesInOrder returns the list of packages, sorted by name.
+func (w *Wrapper) packagesInOrder() []*PackageInfo {
+	return sortedPackages(w.packages)
 }
 
 // allPackagesInOrder returns the list of all packages, sorted by name.
 func (w *Wrapper) allPackagesInOrder() []*PackageInfo {
-	result := make([]*PackageInfo, 0, len(w.allPackages))
-	names := make([]string, 0, len(w.allPackages))
-	for name := range w.allPackages {
+	return sortedPackages(w.allPackages)
+}
+
+// sortedPackages returns the packages in the map, sorted by name.
+func sortedPackages(packages map[string]*PackageInfo) []*PackageInfo {
+	result := make([]*PackageInfo, 0, len(packages))
+	names := make([]string, 0, len(packages))
+	for name := range packages {
 		names = append(names, name)
 	}
 	sort.Strings(names)
 	for _, name := range names {
-		result = append(result, w.allPackages[name])
+		result = append(result, packages[name])
 	}
 	return result
 }
@@ -0,0 +1,263 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/square/goprotowrap/wrapper"
+	"github.com/square/goprotowrap/wrapper/wrappertest"
+)
+
+// goOutputs returns an Outputs function for a fake protoc that writes
+// a .pb.go file with the given contents for each .proto file, in the
+// --go_out directory.
+func goOutputs(contents func(name string) string) func(wrappertest.Invocation) map[string][]byte {
+	return func(inv wrappertest.Invocation) map[string][]byte {
+		var out string
+		for _, flag := range inv.Flags {
+			if strings.HasPrefix(flag, "--go_out=") {
+				out = strings.TrimPrefix(flag, "--go_out=")
+			}
+		}
+		outputs := map[string][]byte{}
+		for _, name := range inv.Files {
+			pbGo := strings.TrimSuffix(name, ".proto") + ".pb.go"
+			outputs[filepath.Join(out, filepath.FromSlash(pbGo))] = []byte(contents(name))
+		}
+		return outputs
+	}
+}
+
+func TestPrintStructureJSON(t *testing.T) {
+	tree := wrappertest.NewTree(t).
+		Add("a/a2.proto", "ex.com/a").
+		Add("a/a.proto", "ex.com/a", "b/b.proto").
+		Add("b/b.proto", "ex.com/b")
+	w := &wrapper.Wrapper{
+		ProtocCommand: "protoc",
+		Executor:      wrappertest.NewProtoc(tree),
+		Parallelism:   1,
+		ImportDirs:    []string{tree.Dir},
+		ProtoFiles:    tree.Paths(),
+	}
+	var buf bytes.Buffer
+	if err := w.PrintStructureJSON(&buf); err == nil {
+		t.Errorf("want error before Init")
+	}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.PrintStructureJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Packages []wrapper.StructurePackage `json:"packages"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("cannot decode %q: %v", buf.String(), err)
+	}
+	file := func(name, goPackage string) wrapper.StructureFile {
+		return wrapper.StructureFile{Name: name, FullPath: tree.Path(name), Package: name[:1], GoPackage: goPackage}
+	}
+	want := []wrapper.StructurePackage{
+		{
+			ComputedPackage: "ex.com/a;a",
+			Files:           []wrapper.StructureFile{file("a/a.proto", "ex.com/a"), file("a/a2.proto", "ex.com/a")},
+			Deps:            []wrapper.StructureFile{file("b/b.proto", "ex.com/b")},
+		},
+		{
+			ComputedPackage: "ex.com/b;b",
+			Files:           []wrapper.StructureFile{file("b/b.proto", "ex.com/b")},
+			Deps:            []wrapper.StructureFile{},
+		},
+	}
+	if !reflect.DeepEqual(got.Packages, want) {
+		t.Errorf("want %+v; got %+v", want, got.Packages)
+	}
+	if structure := w.Structure(); !reflect.DeepEqual(structure, want) {
+		t.Errorf("Structure(): want %+v; got %+v", want, structure)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tree := wrappertest.NewTree(t).
+		Add("a/a.proto", "ex.com/a", "b/b.proto").
+		Add("b/b.proto", "ex.com/b")
+	protoc := wrappertest.NewProtoc(tree)
+	version := "v1"
+	protoc.Outputs = goOutputs(func(name string) string {
+		return "// " + version + "\npackage " + path.Base(path.Dir(name)) + "\n"
+	})
+	out := filepath.Join(tree.Dir, "gen")
+	w := &wrapper.Wrapper{
+		ProtocCommand: "protoc",
+		Executor:      protoc,
+		Parallelism:   2,
+		ProtocFlags:   []string{"-I" + tree.Dir, "--go_out=" + out},
+		ImportDirs:    []string{tree.Dir},
+		ProtoFiles:    tree.Paths(),
+	}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Check(); err != nil {
+		t.Errorf("up to date: want no error; got %v", err)
+	}
+
+	version = "v2"
+	if err := os.Remove(filepath.Join(out, "b", "b.pb.go")); err != nil {
+		t.Fatal(err)
+	}
+	err := w.Check()
+	checkErr, ok := err.(*wrapper.CheckError)
+	if !ok {
+		t.Fatalf("stale: want *CheckError; got %v", err)
+	}
+	want := []wrapper.FileDifference{
+		{Path: filepath.Join(out, "a", "a.pb.go"), Summary: "differs at line 1: 1 line(s) would be replaced by 1"},
+		{Path: filepath.Join(out, "b", "b.pb.go"), Summary: "missing"},
+	}
+	if !reflect.DeepEqual(checkErr.Differences, want) {
+		t.Errorf("want differences %+v; got %+v", want, checkErr.Differences)
+	}
+	if _, err := os.Stat(filepath.Join(out, "b", "b.pb.go")); !os.IsNotExist(err) {
+		t.Errorf("want output directory untouched; got %v", err)
+	}
+}
+
+func TestCheckThenCheckCycles(t *testing.T) {
+	tree := wrappertest.NewTree(t).
+		Add("a/a.proto", "ex.com/a", "b/b.proto").
+		Add("b/b.proto", "ex.com/b", "a/a.proto")
+	protoc := wrappertest.NewProtoc(tree)
+	protoc.Outputs = goOutputs(func(name string) string { return "new" })
+	out := filepath.Join(tree.Dir, "gen")
+	w := &wrapper.Wrapper{
+		ProtocCommand:   "protoc",
+		Executor:        protoc,
+		Parallelism:     2,
+		DependencyOrder: true,
+		ProtocFlags:     []string{"-I" + tree.Dir, "--go_out=" + out},
+		ImportDirs:      []string{tree.Dir},
+		ProtoFiles:      tree.Paths(),
+	}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// Check generates with a copy of the Wrapper; that mustn't affect
+	// the original's view of the package graph.
+	if _, ok := w.Check().(*wrapper.CheckError); !ok {
+		t.Fatal("want *CheckError before generating")
+	}
+	err := w.CheckCycles()
+	cycleErr, ok := err.(*wrapper.CycleError)
+	if !ok {
+		t.Fatalf("want *CycleError; got %v", err)
+	}
+	if want := []string{"ex.com/a;a", "ex.com/b;b"}; len(cycleErr.Cycles) != 1 || !reflect.DeepEqual(cycleErr.Cycles[0].Packages, want) {
+		t.Errorf("want one cycle of %v; got %+v", want, cycleErr.Cycles)
+	}
+}
+
+func TestGenerateAtomic(t *testing.T) {
+	tree := wrappertest.NewTree(t).
+		Add("a/a.proto", "ex.com/a").
+		Add("b/b.proto", "ex.com/b")
+	out := filepath.Join(tree.Dir, "gen")
+	existing := filepath.Join(out, "a", "a.pb.go")
+	if err := os.MkdirAll(filepath.Dir(existing), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(existing, []byte("old"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	newProtoc := func() *wrappertest.Protoc {
+		protoc := wrappertest.NewProtoc(tree)
+		protoc.Outputs = goOutputs(func(name string) string { return "new" })
+		return protoc
+	}
+	// outputs returns the files and directories in the output
+	// directory, and their contents.
+	outputs := func() map[string]string {
+		result := map[string]string{}
+		err := filepath.Walk(out, func(p string, info os.FileInfo, err error) error {
+			if err != nil || p == out {
+				return err
+			}
+			rel, err := filepath.Rel(out, p)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				result[filepath.ToSlash(rel)] = "dir"
+				return nil
+			}
+			contents, err := ioutil.ReadFile(p)
+			result[filepath.ToSlash(rel)] = string(contents)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	w := &wrapper.Wrapper{
+		ProtocCommand: "protoc",
+		Executor:      newProtoc(),
+		Parallelism:   2,
+		ProtocFlags:   []string{"-I" + tree.Dir, "--go_out=" + out},
+		ImportDirs:    []string{tree.Dir},
+		ProtoFiles:    tree.Paths(),
+		Atomic:        true,
+		KeepGoing:     true,
+	}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// If any package fails, nothing is moved into place.
+	failing := newProtoc()
+	failing.Fail("b/b.proto", "boom")
+	w.Executor = failing
+	if err := w.Generate(); err == nil {
+		t.Fatal("want error")
+	}
+	if got, want := outputs(), map[string]string{"a": "dir", "a/a.pb.go": "old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after failure: want %v; got %v", want, got)
+	}
+
+	w.Executor = newProtoc()
+	if err := w.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "dir", "a/a.pb.go": "new", "b": "dir", "b/b.pb.go": "new"}
+	if got := outputs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after success: want %v; got %v", want, got)
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// protoc.go contains a fake protoc, and assertions on its invocations.
+
+package wrappertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Invocation is a single recorded call to a fake Protoc.
+type Invocation struct {
+	Command string   // The protoc command given.
+	Args    []string // The arguments, as given.
+	Flags   []string // The arguments that aren't .proto files, with @argfiles expanded.
+	Files   []string // The import-path-relative names of the .proto files.
+
+	// The --descriptor_set_out file, if this call was to get file
+	// information rather than to generate code.
+	DescriptorSetOut string
+}
+
+// Protoc is a fake protoc, implementing wrapper.Executor. It writes
+// FileDescriptorSets for the files in its Tree, and records every
+// call. Calls to generate code write nothing, unless Outputs is set.
+// It is safe for concurrent use.
+type Protoc struct {
+	Tree *Tree
+
+	// Outputs, if set, returns the files a call to generate code
+	// writes, mapped to their contents. Relative paths are relative
+	// to the working directory.
+	Outputs func(inv Invocation) map[string][]byte
+
+	mu          sync.Mutex
+	invocations []Invocation
+	failures    map[string]string
+}
+
+// NewProtoc returns a fake protoc that knows about the files in the
+// given Tree, including those added later.
+func NewProtoc(tree *Tree) *Protoc {
+	return &Protoc{Tree: tree, failures: map[string]string{}}
+}
+
+// Fail makes calls that include the named file fail, with the given
+// message reported against the file in protoc's format.
+func (p *Protoc) Fail(name, message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures[name] = message
+}
+
+// Run implements wrapper.Executor.
+func (p *Protoc) Run(ctx context.Context, command string, args []string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	inv, err := p.parseArgs(command, args)
+	if err != nil {
+		return []byte(err.Error() + "\n"), errors.New("exit status 1")
+	}
+
+	p.mu.Lock()
+	p.invocations = append(p.invocations, inv)
+	failures := []string{}
+	for _, name := range inv.Files {
+		if message, ok := p.failures[name]; ok {
+			failures = append(failures, fmt.Sprintf("%s:1:1: %s", name, message))
+		}
+	}
+	p.mu.Unlock()
+	if len(failures) > 0 {
+		return []byte(strings.Join(failures, "\n") + "\n"), errors.New("exit status 1")
+	}
+
+	if inv.DescriptorSetOut == "" {
+		return nil, p.writeOutputs(inv)
+	}
+	set, err := p.Tree.DescriptorSet(inv.Files...)
+	if err != nil {
+		return []byte(err.Error() + "\n"), errors.New("exit status 1")
+	}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		return nil, err
+	}
+	return nil, ioutil.WriteFile(inv.DescriptorSetOut, data, 0666)
+}
+
+// writeOutputs writes the files Outputs returns for a call to
+// generate code.
+func (p *Protoc) writeOutputs(inv Invocation) error {
+	if p.Outputs == nil {
+		return nil
+	}
+	for path, contents := range p.Outputs(inv) {
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, contents, 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseArgs parses protoc arguments into an Invocation, resolving the
+// .proto files against the import directories (or the current
+// directory, if none are given).
+func (p *Protoc) parseArgs(command string, args []string) (Invocation, error) {
+	inv := Invocation{Command: command, Args: args}
+	importDirs := []string{}
+	files := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-I" || arg == "--proto_path":
+			if i+1 == len(args) {
+				return inv, fmt.Errorf("Missing value for flag: %s", arg)
+			}
+			i++
+			importDirs = append(importDirs, args[i])
+			inv.Flags = append(inv.Flags, arg, args[i])
+		case strings.HasPrefix(arg, "-I"):
+			importDirs = append(importDirs, arg[2:])
+			inv.Flags = append(inv.Flags, arg)
+		case strings.HasPrefix(arg, "--proto_path="):
+			importDirs = append(importDirs, strings.TrimPrefix(arg, "--proto_path="))
+			inv.Flags = append(inv.Flags, arg)
+		case strings.HasPrefix(arg, "--descriptor_set_out="):
+			inv.DescriptorSetOut = strings.TrimPrefix(arg, "--descriptor_set_out=")
+			inv.Flags = append(inv.Flags, arg)
+		case strings.HasPrefix(arg, "@"):
+			contents, err := ioutil.ReadFile(arg[1:])
+			if err != nil {
+				return inv, err
+			}
+			for _, line := range strings.Split(string(contents), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					files = append(files, line)
+				}
+			}
+		case strings.HasPrefix(arg, "-"):
+			inv.Flags = append(inv.Flags, arg)
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(importDirs) == 0 {
+		importDirs = []string{"."}
+	}
+	for _, file := range files {
+		name, err := fileName(file, importDirs)
+		if err != nil {
+			return inv, err
+		}
+		inv.Files = append(inv.Files, name)
+	}
+	return inv, nil
+}
+
+// fileName returns the import-path-relative name of a .proto file.
+func fileName(file string, importDirs []string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range importDirs {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(absDir, abs)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel), nil
+		}
+	}
+	return "", fmt.Errorf("%s: File does not reside within any path specified using --proto_path (or -I).", file)
+}
+
+// Invocations returns the calls made so far, in order.
+func (p *Protoc) Invocations() []Invocation {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Invocation(nil), p.invocations...)
+}
+
+// Generated returns the flags of the calls made so far to generate
+// code (rather than to get file information), keyed by the go_package
+// of their first file. Later calls for a package replace earlier ones.
+func (p *Protoc) Generated() map[string][]string {
+	result := map[string][]string{}
+	for _, inv := range p.Invocations() {
+		if inv.DescriptorSetOut != "" || len(inv.Files) == 0 {
+			continue
+		}
+		result[p.goPackage(inv.Files[0])] = inv.Flags
+	}
+	return result
+}
+
+// goPackage returns the go_package option of the named file, or its
+// name if it has none.
+func (p *Protoc) goPackage(name string) string {
+	if fd, ok := p.Tree.files[name]; ok && fd.GetOptions().GetGoPackage() != "" {
+		return fd.GetOptions().GetGoPackage()
+	}
+	return name
+}
+
+// Reset forgets the calls made so far.
+func (p *Protoc) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.invocations = nil
+}
+
+// AssertGenerated checks that exactly the packages in want (keyed by
+// go_package) were generated, with the given flags. A nil slice of
+// flags matches any flags.
+func AssertGenerated(t testing.TB, p *Protoc, want map[string][]string) {
+	t.Helper()
+	got := p.Generated()
+	for _, pkg := range sortedKeys(want) {
+		flags, ok := got[pkg]
+		if !ok {
+			t.Errorf("want package %s generated; it wasn't", pkg)
+			continue
+		}
+		if want[pkg] != nil && !reflect.DeepEqual(flags, want[pkg]) {
+			t.Errorf("want package %s generated with flags %q; got %q", pkg, want[pkg], flags)
+		}
+	}
+	for _, pkg := range sortedKeys(got) {
+		if _, ok := want[pkg]; !ok {
+			t.Errorf("want package %s not generated; it was, with flags %q", pkg, got[pkg])
+		}
+	}
+}
+
+// AssertFiles checks that the named package was generated from
+// exactly the given import-path-relative files, in a single call.
+func AssertFiles(t testing.TB, p *Protoc, pkg string, want ...string) {
+	t.Helper()
+	var got []string
+	for _, inv := range p.Invocations() {
+		if inv.DescriptorSetOut == "" && len(inv.Files) > 0 && p.goPackage(inv.Files[0]) == pkg {
+			got = inv.Files
+		}
+	}
+	if got == nil {
+		t.Errorf("want package %s generated; it wasn't", pkg)
+		return
+	}
+	sorted := append([]string(nil), got...)
+	sort.Strings(sorted)
+	want = append([]string(nil), want...)
+	sort.Strings(want)
+	if !reflect.DeepEqual(sorted, want) {
+		t.Errorf("want package %s generated from %q; got %q", pkg, want, sorted)
+	}
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// tree.go contains the builder for synthetic .proto file trees.
+
+// Package wrappertest provides helpers for testing code that uses the
+// wrapper package without a real protoc: builders for trees of
+// synthetic .proto files, a fake protoc that is run through a
+// wrapper.Executor, and assertions on what it was asked to generate.
+package wrappertest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// Tree is a directory of synthetic .proto files, for use as an import
+// directory.
+type Tree struct {
+	Dir   string // The import directory containing the files.
+	t     testing.TB
+	files map[string]*descriptor.FileDescriptorProto
+}
+
+// NewTree returns an empty Tree in a temporary directory, which is
+// removed when the test finishes.
+func NewTree(t testing.TB) *Tree {
+	t.Helper()
+	return &Tree{
+		Dir:   t.TempDir(),
+		t:     t,
+		files: map[string]*descriptor.FileDescriptorProto{},
+	}
+}
+
+// Add writes a .proto file with the given import-path-relative name,
+// go_package option (if non-empty) and imports, and returns the Tree.
+// Its proto package is derived from its directory.
+func (tr *Tree) Add(name, goPackage string, imports ...string) *Tree {
+	tr.t.Helper()
+	fd := &descriptor.FileDescriptorProto{
+		Name:       proto.String(name),
+		Package:    proto.String(protoPackage(name)),
+		Dependency: imports,
+		Syntax:     proto.String("proto3"),
+	}
+	if goPackage != "" {
+		fd.Options = &descriptor.FileOptions{GoPackage: proto.String(goPackage)}
+	}
+
+	lines := []string{`syntax = "proto3";`, "", fmt.Sprintf("package %s;", fd.GetPackage()), ""}
+	for _, imp := range imports {
+		lines = append(lines, fmt.Sprintf("import %q;", imp))
+	}
+	if goPackage != "" {
+		lines = append(lines, "", fmt.Sprintf("option go_package = %q;", goPackage))
+	}
+	fullPath := tr.Path(name)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0777); err != nil {
+		tr.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fullPath, []byte(strings.Join(lines, "\n")+"\n"), 0666); err != nil {
+		tr.t.Fatal(err)
+	}
+	tr.files[name] = fd
+	return tr
+}
+
+// Path returns the full path to the file with the given
+// import-path-relative name.
+func (tr *Tree) Path(name string) string {
+	return filepath.Join(tr.Dir, filepath.FromSlash(name))
+}
+
+// Paths returns the full paths to all the files added, sorted.
+func (tr *Tree) Paths() []string {
+	result := []string{}
+	for _, name := range tr.Names() {
+		result = append(result, tr.Path(name))
+	}
+	return result
+}
+
+// Names returns the import-path-relative names of all the files
+// added, sorted.
+func (tr *Tree) Names() []string {
+	result := make([]string, 0, len(tr.files))
+	for name := range tr.files {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// DescriptorSet returns the FileDescriptorSet protoc would produce for
+// the named files with --include_imports: the files and everything
+// they transitively import, with imports before the files importing
+// them. It returns an error if a file, or one of its imports, hasn't
+// been added.
+func (tr *Tree) DescriptorSet(names ...string) (*descriptor.FileDescriptorSet, error) {
+	set := &descriptor.FileDescriptorSet{}
+	seen := map[string]bool{}
+	var add func(name, importedBy string) error
+	add = func(name, importedBy string) error {
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+		fd, ok := tr.files[name]
+		if !ok {
+			if importedBy != "" {
+				return fmt.Errorf("%s: Import %q was not found or had errors.", importedBy, name)
+			}
+			return fmt.Errorf("%s: File not found.", name)
+		}
+		for _, dep := range fd.Dependency {
+			if err := add(dep, name); err != nil {
+				return err
+			}
+		}
+		set.File = append(set.File, fd)
+		return nil
+	}
+	for _, name := range names {
+		if err := add(name, ""); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// protoPackage returns the proto package for a file: its directory,
+// dotted, or "root" for files at the top of the tree.
+func protoPackage(name string) string {
+	dir := path.Dir(name)
+	if dir == "." {
+		return "root"
+	}
+	return strings.Replace(dir, "/", ".", -1)
+}
@@ -0,0 +1,133 @@
+// Copyright 2016 Square, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrappertest
+
+import (
+	"errors"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/square/goprotowrap/wrapper"
+)
+
+func newWrapper(tree *Tree, protoc *Protoc) *wrapper.Wrapper {
+	return &wrapper.Wrapper{
+		ProtocCommand: "protoc",
+		Executor:      protoc,
+		Parallelism:   2,
+		ProtocFlags:   []string{"-I" + tree.Dir, "--go_out=gen"},
+		ImportDirs:    []string{tree.Dir},
+		ProtoFiles:    tree.Paths(),
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tree := NewTree(t).
+		Add("a/a.proto", "ex.com/a", "b/b.proto").
+		Add("a/a2.proto", "ex.com/a").
+		Add("b/b.proto", "ex.com/b")
+	protoc := NewProtoc(tree)
+	w := newWrapper(tree, protoc)
+
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CheckCycles(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	flags := []string{"-I" + tree.Dir, "--go_out=gen"}
+	AssertGenerated(t, protoc, map[string][]string{"ex.com/a": flags, "ex.com/b": flags})
+	AssertFiles(t, protoc, "ex.com/a", "a/a.proto", "a/a2.proto")
+}
+
+func TestOutputs(t *testing.T) {
+	tree := NewTree(t).
+		Add("a/a.proto", "ex.com/a").
+		Add("b/b.proto", "ex.com/b")
+	protoc := NewProtoc(tree)
+	out := filepath.Join(tree.Dir, "gen")
+	protoc.Outputs = func(inv Invocation) map[string][]byte {
+		outputs := map[string][]byte{}
+		for _, name := range inv.Files {
+			pbGo := strings.TrimSuffix(name, ".proto") + ".pb.go"
+			outputs[filepath.Join(out, pbGo)] = []byte("package " + path.Base(path.Dir(name)) + "\n")
+		}
+		return outputs
+	}
+	w := newWrapper(tree, protoc)
+
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a/a.pb.go", "b/b.pb.go"} {
+		if _, err := ioutil.ReadFile(filepath.Join(out, name)); err != nil {
+			t.Errorf("want %s written: %v", name, err)
+		}
+	}
+}
+
+func TestCycles(t *testing.T) {
+	tree := NewTree(t).
+		Add("a/a.proto", "ex.com/a", "b/b.proto").
+		Add("b/b.proto", "ex.com/b", "c/c.proto").
+		Add("c/c.proto", "ex.com/b", "a/a.proto")
+	w := newWrapper(tree, NewProtoc(tree))
+
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.CheckCycles().(*wrapper.CycleError); !ok {
+		t.Errorf("want *CycleError")
+	}
+}
+
+func TestFail(t *testing.T) {
+	tree := NewTree(t).
+		Add("a/a.proto", "ex.com/a").
+		Add("b/b.proto", "ex.com/b")
+	protoc := NewProtoc(tree)
+	w := newWrapper(tree, protoc)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	protoc.Fail("b/b.proto", "Expected \";\".")
+	err := w.Generate()
+	var protocErr *wrapper.ProtocError
+	if !errors.As(err, &protocErr) {
+		t.Fatalf("want *ProtocError; got %v", err)
+	}
+	diags := wrapper.Diagnostics(err)
+	if len(diags) != 1 || diags[0].File != "b/b.proto" || diags[0].Line != 1 {
+		t.Errorf("want one diagnostic for b/b.proto:1; got %v", diags)
+	}
+}
+
+func TestMissingImport(t *testing.T) {
+	tree := NewTree(t).Add("a/a.proto", "ex.com/a", "missing.proto")
+	w := newWrapper(tree, NewProtoc(tree))
+	if err := w.Init(); err == nil {
+		t.Error("want error for missing import")
+	}
+}